@@ -0,0 +1,145 @@
+// Package auth authenticates requests to the dashboard and assigns them roles.
+package auth
+
+import (
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"strings"
+)
+
+type Role int
+
+const (
+	NoRole   Role = iota // May not access anything
+	ReadOnly Role = iota // May view environments and deploys
+	Operator Role = iota // May also change things, e.g. start deploys
+)
+
+var RoleNames = [...]string{
+	"none",
+	"read-only",
+	"operator",
+}
+
+func ParseRole(name string) (Role, error) {
+	for index, roleName := range RoleNames {
+		if roleName == name {
+			return Role(index), nil
+		}
+	}
+
+	return NoRole, fmt.Errorf("Invalid role %q (must be none, read-only, or operator)", name)
+}
+
+func (role Role) String() string {
+	return RoleNames[role]
+}
+
+func (role *Role) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	err := unmarshal(&name)
+	if err != nil {
+		return err
+	}
+
+	*role, err = ParseRole(name)
+	return err
+}
+
+// Who made a request
+type Identity struct {
+	User   string
+	Role   Role
+	Method string // How the user was authenticated
+}
+
+// A way of authenticating requests.
+type Authenticator interface {
+	// Check the credentials in the request. Returns nil if the request doesn't
+	// have credentials this authenticator understands. Returns an error if it
+	// does, but they're not valid.
+	Authenticate(ctx *fasthttp.RequestCtx) (*Identity, error)
+}
+
+// Get a bearer token from the Authorization header, or from the
+// X-Authentication header used by Puppet Enterprise.
+func bearerToken(ctx *fasthttp.RequestCtx) string {
+	header := string(ctx.Request.Header.Peek("Authorization"))
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+
+	return strings.TrimSpace(string(ctx.Request.Header.Peek("X-Authentication")))
+}
+
+// Authenticates requests with a list of authenticators. The first one that
+// recognizes the request's credentials decides.
+type Authenticators struct {
+	Authenticators []Authenticator
+
+	// Role for requests without credentials
+	AnonymousRole Role
+
+	// Sent in WWW-Authenticate so browsers ask for a password
+	BasicRealm string
+}
+
+// Returns an error if the request has invalid credentials.
+func (authenticators *Authenticators) Authenticate(ctx *fasthttp.RequestCtx) (*Identity, error) {
+	for _, authenticator := range authenticators.Authenticators {
+		identity, err := authenticator.Authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if identity != nil {
+			return identity, nil
+		}
+	}
+
+	if bearerToken(ctx) != "" || len(ctx.Request.Header.Peek("Authorization")) > 0 {
+		return nil, fmt.Errorf("Unrecognized credentials")
+	}
+
+	return &Identity{Role: authenticators.AnonymousRole, Method: "anonymous"}, nil
+}
+
+// Wrap a handler so that it's only run for requests with at least role.
+func (authenticators *Authenticators) Require(role Role, handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		identity, err := authenticators.Authenticate(ctx)
+		if err != nil {
+			authenticators.deny(ctx, 401, err.Error())
+			return
+		}
+
+		if identity.Role < role {
+			if identity.Method == "anonymous" {
+				authenticators.deny(ctx, 401, "Authentication required")
+			} else {
+				authenticators.deny(ctx, 403, fmt.Sprintf("%s role required", role))
+			}
+			return
+		}
+
+		ctx.SetUserValue("identity", identity)
+		handler(ctx)
+	}
+}
+
+func (authenticators *Authenticators) deny(ctx *fasthttp.RequestCtx, statusCode int, message string) {
+	if statusCode == 401 && authenticators.BasicRealm != "" {
+		ctx.Response.Header.Set("WWW-Authenticate",
+			fmt.Sprintf("Basic realm=%q", authenticators.BasicRealm))
+	}
+
+	ctx.SetStatusCode(statusCode)
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBodyString(message + "\n")
+}
+
+// The identity of an authenticated request, or nil.
+func RequestIdentity(ctx *fasthttp.RequestCtx) *Identity {
+	identity, _ := ctx.UserValue("identity").(*Identity)
+	return identity
+}
@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// Make a request with headers to a handler that requires role, and return the
+// response status and the identity the handler saw.
+func request(t *testing.T, authenticators *Authenticators, role Role, headers map[string]string) (int, *Identity) {
+	t.Helper()
+
+	ctx := &fasthttp.RequestCtx{}
+	for name, value := range headers {
+		ctx.Request.Header.Set(name, value)
+	}
+
+	var identity *Identity
+	authenticators.Require(role, func(ctx *fasthttp.RequestCtx) {
+		identity = RequestIdentity(ctx)
+		ctx.SetStatusCode(200)
+	})(ctx)
+
+	return ctx.Response.StatusCode(), identity
+}
+
+func bearer(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+func basic(user string, password string) map[string]string {
+	credentials := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+	return map[string]string{"Authorization": "Basic " + credentials}
+}
+
+func newAuthenticators(t *testing.T, config Config) *Authenticators {
+	t.Helper()
+
+	authenticators, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authenticators
+}
+
+func rolePointer(role Role) *Role {
+	return &role
+}
+
+func TestAnonymousRole(t *testing.T) {
+	// No methods configured: anyone may view, but not operate.
+	authenticators := newAuthenticators(t, Config{})
+	if status, identity := request(t, authenticators, ReadOnly, nil); status != 200 || identity.Method != "anonymous" {
+		t.Errorf("anonymous read-only: got %d %+v, want 200 anonymous", status, identity)
+	}
+	if status, _ := request(t, authenticators, Operator, nil); status != 401 {
+		t.Errorf("anonymous operator: got %d, want 401", status)
+	}
+
+	// Once a method is configured, anonymous requests get no role.
+	tokens := []TokenConfig{{User: "ci", Token: "secret"}}
+	authenticators = newAuthenticators(t, Config{Tokens: tokens})
+	if status, _ := request(t, authenticators, ReadOnly, nil); status != 401 {
+		t.Errorf("anonymous with tokens: got %d, want 401", status)
+	}
+
+	// Unless anonymous-role says otherwise.
+	authenticators = newAuthenticators(t, Config{
+		Tokens:        tokens,
+		AnonymousRole: rolePointer(ReadOnly),
+	})
+	if status, _ := request(t, authenticators, ReadOnly, nil); status != 200 {
+		t.Errorf("anonymous-role read-only: got %d, want 200", status)
+	}
+}
+
+func TestStaticToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("operator-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	authenticators := newAuthenticators(t, Config{
+		Tokens: []TokenConfig{
+			{User: "grafana", Token: "viewer-token"},
+			{User: "ci", TokenFile: tokenFile, Role: rolePointer(Operator)},
+		},
+	})
+
+	status, identity := request(t, authenticators, ReadOnly, bearer("viewer-token"))
+	if status != 200 || identity.User != "grafana" || identity.Role != ReadOnly {
+		t.Errorf("viewer token: got %d %+v", status, identity)
+	}
+
+	if status, _ := request(t, authenticators, Operator, bearer("viewer-token")); status != 403 {
+		t.Errorf("viewer token as operator: got %d, want 403", status)
+	}
+
+	headers := map[string]string{"X-Authentication": "operator-token"}
+	status, identity = request(t, authenticators, Operator, headers)
+	if status != 200 || identity.User != "ci" || identity.Method != "token" {
+		t.Errorf("operator token: got %d %+v", status, identity)
+	}
+
+	if status, _ := request(t, authenticators, ReadOnly, bearer("wrong")); status != 401 {
+		t.Errorf("unknown token: got %d, want 401", status)
+	}
+}
+
+func TestHtpasswd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for user, password := range map[string]string{"alice": "secret", "bob": "hunter2"} {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(user + ":" + string(hash) + "\n")
+	}
+	file.Close()
+
+	authenticators := newAuthenticators(t, Config{
+		Htpasswd: &HtpasswdConfig{File: path, Operators: []string{"alice"}},
+	})
+
+	status, identity := request(t, authenticators, Operator, basic("alice", "secret"))
+	if status != 200 || identity.User != "alice" || identity.Method != "htpasswd" {
+		t.Errorf("alice: got %d %+v", status, identity)
+	}
+
+	if status, _ := request(t, authenticators, ReadOnly, basic("bob", "hunter2")); status != 200 {
+		t.Errorf("bob as read-only: got %d, want 200", status)
+	}
+	if status, _ := request(t, authenticators, Operator, basic("bob", "hunter2")); status != 403 {
+		t.Errorf("bob as operator: got %d, want 403", status)
+	}
+
+	for _, headers := range []map[string]string{
+		basic("alice", "wrong"),
+		basic("mallory", "secret"),
+		nil,
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		for name, value := range headers {
+			ctx.Request.Header.Set(name, value)
+		}
+		authenticators.Require(ReadOnly, func(*fasthttp.RequestCtx) {})(ctx)
+
+		if status := ctx.Response.StatusCode(); status != 401 {
+			t.Errorf("%v: got %d, want 401", headers, status)
+		}
+		if challenge := ctx.Response.Header.Peek("WWW-Authenticate"); len(challenge) == 0 {
+			t.Errorf("%v: no WWW-Authenticate header", headers)
+		}
+	}
+}
+
+func TestLoadHtpasswdRejectsOtherHashes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	err := ioutil.WriteFile(path, []byte("carol:$apr1$abc$defghijklmnopqrstuvwx.\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadHtpasswd(path, nil, ReadOnly); err == nil {
+		t.Error("LoadHtpasswd accepted an MD5 hash")
+	}
+}
+
+// A stand-in for the RBAC token authentication endpoint.
+func rbacStub(t *testing.T, requests *int32) *httptest.Server {
+	subjects := map[string]rbacSubject{
+		"admin-token":  {Login: "admin", RoleIds: []int{1}},
+		"viewer-token": {Login: "viewer", RoleIds: []int{3}},
+		"bob-token":    {Login: "bob", RoleIds: []int{3}},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+
+		var body struct {
+			Token              string `json:"token"`
+			UpdateLastActivity *bool  `json:"update_last_activity?"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("RBAC stub: bad request: %v", err)
+		}
+		if body.UpdateLastActivity == nil || *body.UpdateLastActivity {
+			t.Error("RBAC stub: update_last_activity? should be false")
+		}
+
+		subject, found := subjects[body.Token]
+		if !found {
+			w.WriteHeader(401)
+			w.Write([]byte(`{"kind": "puppetlabs.rbac/token-revoked"}`))
+			return
+		}
+
+		json.NewEncoder(w).Encode(subject)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestRbac(t *testing.T) {
+	var requests int32
+	stub := rbacStub(t, &requests)
+
+	authenticators := newAuthenticators(t, Config{
+		Rbac: &RbacConfig{
+			Url:             stub.URL,
+			Operators:       []string{"bob"},
+			OperatorRoleIds: []int{1},
+		},
+	})
+
+	status, identity := request(t, authenticators, Operator, bearer("admin-token"))
+	if status != 200 || identity.User != "admin" || identity.Method != "rbac" {
+		t.Errorf("admin: got %d %+v", status, identity)
+	}
+
+	if status, _ := request(t, authenticators, Operator, bearer("bob-token")); status != 200 {
+		t.Errorf("bob as operator: got %d, want 200", status)
+	}
+
+	if status, _ := request(t, authenticators, ReadOnly, bearer("viewer-token")); status != 200 {
+		t.Errorf("viewer as read-only: got %d, want 200", status)
+	}
+	if status, _ := request(t, authenticators, Operator, bearer("viewer-token")); status != 403 {
+		t.Errorf("viewer as operator: got %d, want 403", status)
+	}
+
+	if status, _ := request(t, authenticators, ReadOnly, bearer("revoked-token")); status != 401 {
+		t.Errorf("revoked token: got %d, want 401", status)
+	}
+
+	// Valid tokens are cached; invalid ones are checked every time.
+	before := atomic.LoadInt32(&requests)
+	request(t, authenticators, ReadOnly, bearer("admin-token"))
+	request(t, authenticators, ReadOnly, bearer("revoked-token"))
+	if made := atomic.LoadInt32(&requests) - before; made != 1 {
+		t.Errorf("made %d RBAC requests, want 1", made)
+	}
+}
+
+func TestRbacUnavailable(t *testing.T) {
+	var requests int32
+	stub := rbacStub(t, &requests)
+	url := stub.URL
+	stub.Close()
+
+	authenticators := newAuthenticators(t, Config{Rbac: &RbacConfig{Url: url}})
+	if status, _ := request(t, authenticators, ReadOnly, bearer("admin-token")); status != 401 {
+		t.Errorf("RBAC down: got %d, want 401", status)
+	}
+}
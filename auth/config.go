@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// The auth section of the configuration file.
+type Config struct {
+	// Role for requests without credentials. Defaults to read-only if no
+	// authentication methods are configured, and none otherwise.
+	AnonymousRole *Role `yaml:"anonymous-role"`
+
+	Tokens   []TokenConfig   `yaml:"tokens"`
+	Htpasswd *HtpasswdConfig `yaml:"htpasswd"`
+	Rbac     *RbacConfig     `yaml:"rbac"`
+}
+
+// A static bearer token.
+type TokenConfig struct {
+	User      string `yaml:"user"`
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token-file"`
+	Role      *Role  `yaml:"role"` // Defaults to read-only
+}
+
+type HtpasswdConfig struct {
+	File      string   `yaml:"file"`
+	Realm     string   `yaml:"realm"`
+	Operators []string `yaml:"operators"`
+	Role      *Role    `yaml:"role"` // For users not in Operators; defaults to read-only
+}
+
+type RbacConfig struct {
+	Url             string        `yaml:"url"`
+	CaFile          string        `yaml:"ca-file"`
+	Timeout         time.Duration `yaml:"timeout"`
+	CacheTime       time.Duration `yaml:"cache-time"`
+	Operators       []string      `yaml:"operators"`         // Logins
+	OperatorRoleIds []int         `yaml:"operator-role-ids"` // RBAC role IDs
+	Role            *Role         `yaml:"role"`              // For everyone else; defaults to read-only
+}
+
+const DefaultRealm = "Code Manager dashboard"
+const DefaultRbacTimeout = 10 * time.Second
+
+func (config *Config) IsEmpty() bool {
+	return len(config.Tokens) == 0 && config.Htpasswd == nil && config.Rbac == nil
+}
+
+func roleOrDefault(role *Role, defaultRole Role) Role {
+	if role == nil {
+		return defaultRole
+	}
+	return *role
+}
+
+func New(config Config) (*Authenticators, error) {
+	authenticators := &Authenticators{
+		AnonymousRole: roleOrDefault(config.AnonymousRole, NoRole),
+	}
+	if config.IsEmpty() {
+		authenticators.AnonymousRole = roleOrDefault(config.AnonymousRole, ReadOnly)
+	}
+
+	if len(config.Tokens) > 0 {
+		tokens, err := newTokenAuthenticator(config.Tokens)
+		if err != nil {
+			return nil, err
+		}
+		authenticators.Authenticators = append(authenticators.Authenticators, tokens)
+	}
+
+	if config.Rbac != nil {
+		rbac, err := newRbacAuthenticator(config.Rbac)
+		if err != nil {
+			return nil, err
+		}
+		authenticators.Authenticators = append(authenticators.Authenticators, rbac)
+	}
+
+	if config.Htpasswd != nil {
+		if config.Htpasswd.File == "" {
+			return nil, fmt.Errorf("auth htpasswd: no file")
+		}
+
+		roles := map[string]Role{}
+		for _, user := range config.Htpasswd.Operators {
+			roles[user] = Operator
+		}
+
+		htpasswd, err := LoadHtpasswd(config.Htpasswd.File, roles,
+			roleOrDefault(config.Htpasswd.Role, ReadOnly))
+		if err != nil {
+			return nil, err
+		}
+		authenticators.Authenticators = append(authenticators.Authenticators, htpasswd)
+
+		authenticators.BasicRealm = config.Htpasswd.Realm
+		if authenticators.BasicRealm == "" {
+			authenticators.BasicRealm = DefaultRealm
+		}
+	}
+
+	return authenticators, nil
+}
+
+func newTokenAuthenticator(configs []TokenConfig) (*TokenAuthenticator, error) {
+	authenticator := &TokenAuthenticator{}
+	for i, config := range configs {
+		name := config.User
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+
+		token := config.Token
+		if config.TokenFile != "" {
+			if token != "" {
+				return nil, fmt.Errorf("auth token %s: both token and token-file set", name)
+			}
+
+			contents, err := ioutil.ReadFile(config.TokenFile)
+			if err != nil {
+				return nil, err
+			}
+			token = strings.TrimSpace(string(contents))
+		}
+
+		if token == "" {
+			return nil, fmt.Errorf("auth token %s: no token", name)
+		}
+
+		authenticator.Tokens = append(authenticator.Tokens, StaticToken{
+			Token: token,
+			Identity: Identity{
+				User: config.User,
+				Role: roleOrDefault(config.Role, ReadOnly),
+			},
+		})
+	}
+
+	return authenticator, nil
+}
+
+func newRbacAuthenticator(config *RbacConfig) (*RbacAuthenticator, error) {
+	if config.Url == "" {
+		return nil, fmt.Errorf("auth rbac: no url")
+	}
+
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = DefaultRbacTimeout
+	}
+
+	authenticator, err := NewRbacAuthenticator(config.Url, config.CaFile, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range config.Operators {
+		authenticator.OperatorUsers[user] = true
+	}
+	for _, id := range config.OperatorRoleIds {
+		authenticator.OperatorRoleIds[id] = true
+	}
+	authenticator.DefaultRole = roleOrDefault(config.Role, ReadOnly)
+	if config.CacheTime > 0 {
+		authenticator.CacheTime = config.CacheTime
+	}
+
+	return authenticator, nil
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strings"
+)
+
+// Accepts HTTP basic authentication checked against an htpasswd file. Only
+// bcrypt hashes are supported.
+type HtpasswdAuthenticator struct {
+	hashes map[string][]byte
+	roles  map[string]Role
+
+	// Checked for unknown users, so that they take as long to reject as known
+	// users with the wrong password.
+	dummyHash []byte
+
+	// Role for users not in roles
+	DefaultRole Role
+}
+
+// Load an htpasswd file, e.g. one created with `htpasswd -B`. roles assigns
+// roles to specific users.
+func LoadHtpasswd(path string, roles map[string]Role, defaultRole Role) (*HtpasswdAuthenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	authenticator := &HtpasswdAuthenticator{
+		hashes:      map[string][]byte{},
+		roles:       roles,
+		DefaultRole: defaultRole,
+	}
+
+	maxCost := bcrypt.MinCost
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", path, line)
+		}
+
+		cost, err := bcrypt.Cost([]byte(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: user %q does not have a bcrypt hash",
+				path, line, parts[0])
+		}
+		if cost > maxCost {
+			maxCost = cost
+		}
+
+		authenticator.hashes[parts[0]] = []byte(parts[1])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	authenticator.dummyHash, err = bcrypt.GenerateFromPassword([]byte("dummy"), maxCost)
+	if err != nil {
+		return nil, err
+	}
+
+	return authenticator, nil
+}
+
+func (authenticator *HtpasswdAuthenticator) Authenticate(ctx *fasthttp.RequestCtx) (*Identity, error) {
+	user, password, ok := basicAuth(ctx)
+	if !ok {
+		return nil, nil
+	}
+
+	hash, found := authenticator.hashes[user]
+	if !found {
+		hash = authenticator.dummyHash
+	}
+
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if !found || err != nil {
+		return nil, fmt.Errorf("Invalid user or password")
+	}
+
+	role, found := authenticator.roles[user]
+	if !found {
+		role = authenticator.DefaultRole
+	}
+
+	return &Identity{User: user, Role: role, Method: "htpasswd"}, nil
+}
+
+// Parse an Authorization: Basic header.
+func basicAuth(ctx *fasthttp.RequestCtx) (user string, password string, ok bool) {
+	header := string(ctx.Request.Header.Peek("Authorization"))
+	if len(header) < 6 || !strings.EqualFold(header[:6], "Basic ") {
+		return "", "", false
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header[6:]))
+	if err != nil {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
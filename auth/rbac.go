@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const DefaultRbacCacheTime = time.Minute
+
+// Validates bearer tokens with the Puppet Enterprise RBAC API, e.g.
+// https://puppet.example.com:4433/rbac-api/v2/auth/token/authenticate
+type RbacAuthenticator struct {
+	Url        string
+	httpClient *http.Client
+
+	// Users get Operator if their login is in OperatorUsers, or if they have
+	// a role in OperatorRoleIds. Otherwise they get DefaultRole.
+	OperatorUsers   map[string]bool
+	OperatorRoleIds map[int]bool
+	DefaultRole     Role
+
+	// How long to remember valid tokens
+	CacheTime time.Duration
+	cache     map[[sha256.Size]byte]rbacCacheEntry
+	cacheLock sync.Mutex
+}
+
+type rbacCacheEntry struct {
+	identity  Identity
+	expiresAt time.Time
+}
+
+// The parts of the RBAC response we care about
+type rbacSubject struct {
+	Login   string `json:"login"`
+	RoleIds []int  `json:"role_ids"`
+}
+
+// caPath may be "" to use the system CAs.
+func NewRbacAuthenticator(url string, caPath string, timeout time.Duration) (*RbacAuthenticator, error) {
+	authenticator := &RbacAuthenticator{
+		Url:             url,
+		OperatorUsers:   map[string]bool{},
+		OperatorRoleIds: map[int]bool{},
+		DefaultRole:     ReadOnly,
+		CacheTime:       DefaultRbacCacheTime,
+		cache:           map[[sha256.Size]byte]rbacCacheEntry{},
+	}
+
+	if caPath == "" {
+		authenticator.httpClient = codemanager.ApiHttpClient(nil, timeout)
+	} else {
+		tlsConfig, err := codemanager.LoadCaCert(caPath)
+		if err != nil {
+			return nil, err
+		}
+		authenticator.httpClient = codemanager.ApiHttpClient(tlsConfig, timeout)
+	}
+
+	return authenticator, nil
+}
+
+func (authenticator *RbacAuthenticator) Authenticate(ctx *fasthttp.RequestCtx) (*Identity, error) {
+	token := bearerToken(ctx)
+	if token == "" {
+		return nil, nil
+	}
+
+	key := sha256.Sum256([]byte(token))
+	if identity := authenticator.cached(key); identity != nil {
+		return identity, nil
+	}
+
+	subject, err := authenticator.validate(token)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := Identity{
+		User:   subject.Login,
+		Role:   authenticator.role(subject),
+		Method: "rbac",
+	}
+
+	authenticator.cacheLock.Lock()
+	authenticator.cache[key] = rbacCacheEntry{
+		identity:  identity,
+		expiresAt: time.Now().Add(authenticator.CacheTime),
+	}
+	authenticator.cacheLock.Unlock()
+
+	return &identity, nil
+}
+
+func (authenticator *RbacAuthenticator) cached(key [sha256.Size]byte) *Identity {
+	authenticator.cacheLock.Lock()
+	defer authenticator.cacheLock.Unlock()
+
+	now := time.Now()
+	for otherKey, entry := range authenticator.cache {
+		if now.After(entry.expiresAt) {
+			delete(authenticator.cache, otherKey)
+		}
+	}
+
+	entry, found := authenticator.cache[key]
+	if !found {
+		return nil
+	}
+
+	return &entry.identity
+}
+
+func (authenticator *RbacAuthenticator) role(subject *rbacSubject) Role {
+	if authenticator.OperatorUsers[subject.Login] {
+		return Operator
+	}
+
+	for _, id := range subject.RoleIds {
+		if authenticator.OperatorRoleIds[id] {
+			return Operator
+		}
+	}
+
+	return authenticator.DefaultRole
+}
+
+// Ask RBAC who the token belongs to.
+func (authenticator *RbacAuthenticator) validate(token string) (*rbacSubject, error) {
+	request, err := json.Marshal(map[string]interface{}{
+		"token":                 token,
+		"update_last_activity?": false,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := authenticator.httpClient.Post(authenticator.Url,
+		"application/json", bytes.NewReader(request))
+	if err != nil {
+		log.Errorf("Error validating token with RBAC: %v", err)
+		return nil, fmt.Errorf("Could not validate token")
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		log.Errorf("Error validating token with RBAC: %v", err)
+		return nil, fmt.Errorf("Could not validate token")
+	}
+
+	if response.StatusCode != 200 {
+		log.Debugf("RBAC rejected token: %d %s", response.StatusCode, body)
+		return nil, fmt.Errorf("Invalid token")
+	}
+
+	subject := rbacSubject{}
+	err = json.Unmarshal(body, &subject)
+	if err != nil || subject.Login == "" {
+		log.Errorf("Unexpected response from RBAC: %s", body)
+		return nil, fmt.Errorf("Could not validate token")
+	}
+
+	return &subject, nil
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"github.com/valyala/fasthttp"
+)
+
+type StaticToken struct {
+	Token string
+	Identity
+}
+
+// Accepts bearer tokens from a fixed list.
+type TokenAuthenticator struct {
+	Tokens []StaticToken
+}
+
+func (authenticator *TokenAuthenticator) Authenticate(ctx *fasthttp.RequestCtx) (*Identity, error) {
+	token := bearerToken(ctx)
+	if token == "" {
+		return nil, nil
+	}
+
+	for i := range authenticator.Tokens {
+		entry := &authenticator.Tokens[i]
+		if subtle.ConstantTimeCompare([]byte(token), []byte(entry.Token)) == 1 {
+			identity := entry.Identity
+			identity.Method = "token"
+			return &identity, nil
+		}
+	}
+
+	// Another authenticator might accept it.
+	return nil, nil
+}
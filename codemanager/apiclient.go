@@ -1,13 +1,16 @@
 package codemanager
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/json"
 	"fmt"
+	log "github.com/sirupsen/logrus"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -16,77 +19,205 @@ type ApiClient struct {
 	Port       uint16
 	RbacToken  string
 	HttpClient *http.Client
+	Retry      RetryPolicy
 }
 
-func TypicalApiClient(host string, rbacToken string, caPath string) *ApiClient {
-	return &ApiClient{
-		Host:       host,
-		Port:       8170,
-		RbacToken:  rbacToken,
-		HttpClient: ApiHttpClient(LoadCaCert(caPath)),
+const DefaultPort = 8170
+const DefaultTimeout = 5 * time.Minute
+const DefaultConnectTimeout = 10 * time.Second
+
+// Settings for connecting to a Code Manager server
+type ClientConfig struct {
+	// Distinguishes servers when there are several. The default server is "".
+	Name string `yaml:"name"`
+
+	Host      string `yaml:"host"`
+	Port      uint16 `yaml:"port"`
+	CaPath    string `yaml:"ca-file"`
+	TokenPath string `yaml:"token-file"`
+
+	// Timeout limits each whole request, including retries. ConnectTimeout
+	// limits connecting and the TLS handshake, and ReadTimeout limits waiting
+	// for a response once the request is sent. Zero means no limit.
+	Timeout        time.Duration `yaml:"timeout"`
+	ConnectTimeout time.Duration `yaml:"connect-timeout"`
+	ReadTimeout    time.Duration `yaml:"read-timeout"`
+
+	// Retry requests that fail with temporary errors.
+	Retries         int           `yaml:"retries"`
+	RetryBackoff    time.Duration `yaml:"retry-backoff"`
+	RetryMaxBackoff time.Duration `yaml:"retry-max-backoff"`
+
+	// Loaded from TokenPath by LoadToken, or set directly.
+	RbacToken string `yaml:"-"`
+}
+
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		Port:            DefaultPort,
+		Timeout:         DefaultTimeout,
+		ConnectTimeout:  DefaultConnectTimeout,
+		Retries:         DefaultRetryPolicy.Retries,
+		RetryBackoff:    DefaultRetryPolicy.Backoff,
+		RetryMaxBackoff: DefaultRetryPolicy.MaxBackoff,
 	}
 }
 
+// Settings not in the YAML get their default values.
+func (config *ClientConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*config = DefaultClientConfig()
+	type plain ClientConfig
+	return unmarshal((*plain)(config))
+}
+
+// Read the RBAC token from TokenPath, if it's set.
+func (config *ClientConfig) LoadToken() error {
+	if config.TokenPath == "" {
+		return nil
+	}
+
+	token, err := ioutil.ReadFile(config.TokenPath)
+	if err != nil {
+		return err
+	}
+
+	config.RbacToken = strings.TrimSpace(string(token))
+	return nil
+}
+
+func TypicalApiClient(config ClientConfig) (*ApiClient, error) {
+	var tlsConfig *tls.Config
+	if config.CaPath != "" {
+		var err error
+		tlsConfig, err = LoadCaCert(config.CaPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	httpClient := ApiHttpClient(tlsConfig, config.Timeout)
+	transport := httpClient.Transport.(*http.Transport)
+	transport.DialContext = (&net.Dialer{Timeout: config.ConnectTimeout}).DialContext
+	transport.TLSHandshakeTimeout = config.ConnectTimeout
+	transport.ResponseHeaderTimeout = config.ReadTimeout
+
+	return &ApiClient{
+		Host:       config.Host,
+		Port:       config.Port,
+		RbacToken:  config.RbacToken,
+		HttpClient: httpClient,
+		Retry: RetryPolicy{
+			Retries:    config.Retries,
+			Backoff:    config.RetryBackoff,
+			MaxBackoff: config.RetryMaxBackoff,
+			MaxTime:    config.Timeout,
+		},
+	}, nil
+}
+
 // Create a tls.Config that recognizes a named CA cert
-func LoadCaCert(path string) *tls.Config {
+func LoadCaCert(path string) (*tls.Config, error) {
 	caCert, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
+	if !caCertPool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("No certificates found in %q", path)
+	}
 
 	return &tls.Config{
 		RootCAs: caCertPool,
-	}
+	}, nil
 }
 
-func ApiHttpClient(tlsConfig *tls.Config) *http.Client {
+func ApiHttpClient(tlsConfig *tls.Config, timeout time.Duration) *http.Client {
 	return &http.Client{
 		Transport: &http.Transport{TLSClientConfig: tlsConfig},
-		Timeout:   5 * time.Minute,
+		Timeout:   timeout,
 	}
 }
 
-func getRawCodeStateJson(client *ApiClient) []byte {
-	url := fmt.Sprintf("https://%s:%d/code-manager/v1/deploys/status",
-		client.Host, client.Port)
+func (client *ApiClient) url(path string) string {
+	return fmt.Sprintf("https://%s:%d%s", client.Host, client.Port, path)
+}
 
-	request, err := http.NewRequest("GET", url, nil)
+// Make a request to the API and return the body of the response. Temporary
+// failures are retried according to client.Retry.
+func (client *ApiClient) request(method string, path string, body []byte) ([]byte, error) {
+	ctx := context.Background()
+	if client.Retry.MaxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.Retry.MaxTime)
+		defer cancel()
+	}
+
+	for attempt := 0; ; attempt++ {
+		responseBody, err := client.requestOnce(ctx, method, path, body)
+		if err == nil || attempt >= client.Retry.Retries || !isRetryable(method, err) {
+			return responseBody, err
+		}
+
+		delay := client.Retry.delay(attempt, err)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			// There's no time left for another attempt.
+			return responseBody, err
+		}
+
+		log.Warnf("%v; retrying in %v", err, delay.Round(time.Millisecond))
+		time.Sleep(delay)
+	}
+}
+
+func (client *ApiClient) requestOnce(ctx context.Context, method string, path string, body []byte) ([]byte, error) {
+	url := client.url(path)
+
+	request, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
 
 	if client.RbacToken != "" {
 		request.Header.Set("X-Authentication", client.RbacToken)
 	}
 
+	log.Debugf("%s %s", method, url)
 	response, err := client.HttpClient.Do(request)
 	if err != nil {
-		log.Fatal(err)
+		return nil, &TransportError{Url: url, Err: err}
 	}
+	defer response.Body.Close()
 
-	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		log.Fatalf("Unexpected status %q checking deployment status.", response.Status)
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, &TransportError{Url: url, Err: err}
 	}
 
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Fatal(err)
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return nil, &HttpStatusError{
+			Url:        url,
+			StatusCode: response.StatusCode,
+			Status:     response.Status,
+			Body:       responseBody,
+			RetryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+		}
 	}
 
-	return body
+	return responseBody, nil
 }
 
-func (client *ApiClient) GetRawCodeState() map[string]interface{} {
-	codeState := map[string]interface{}{}
-	err := json.Unmarshal(getRawCodeStateJson(client), &codeState)
+func (client *ApiClient) GetRawCodeState() (*RawCodeState, error) {
+	path := "/code-manager/v1/deploys/status"
+	body, err := client.request("GET", path, nil)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	return codeState
+	return DecodeRawCodeState(client.url(path), body)
 }
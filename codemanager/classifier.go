@@ -0,0 +1,165 @@
+package codemanager
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// Category for failed deploys that don't match any rule
+const OtherCategory = "other"
+
+// A rule for categorizing failed deploys. A rule matches if all of its
+// non-empty criteria match.
+type ClassifierRule struct {
+	Category string `yaml:"category"`
+
+	Kind    string `yaml:"kind"`    // Exact match on the error kind
+	Pattern string `yaml:"pattern"` // Regular expression matched on the message
+
+	// Change the deploy's status, e.g. to "deleted" for failures that really
+	// mean the environment was removed.
+	Status string `yaml:"status"`
+
+	pattern *regexp.Regexp
+	status  DeployStatus
+}
+
+// Assigns categories to failed deploys. The first matching rule wins.
+type Classifier struct {
+	Rules []ClassifierRule
+}
+
+// Used if no rules are configured.
+var DefaultClassifierRules = []ClassifierRule{
+	{
+		Category: "environment-not-found",
+		Pattern:  `cannot be found in any source and will not be deployed`,
+		Status:   "deleted",
+	},
+	{
+		Category: "missing-git-object",
+		Pattern:  `Object not found - no match for id|(?i)unknown revision|reference is not a tree`,
+	},
+	{
+		Category: "puppetfile-syntax",
+		Pattern:  `(?i)Puppetfile.*(syntax|parse|evaluat)|(syntax|parse) error.*Puppetfile`,
+	},
+	{
+		Category: "module-fetch",
+		Pattern:  `(?i)(could(n't| not) (fetch|resolve|find module|clone)|failed to (fetch|clone|download)|unable to access|forge.*(not found|error))`,
+	},
+	{
+		Category: "timeout",
+		Pattern:  `(?i)time(d)? ?out`,
+	},
+}
+
+var currentClassifier struct {
+	sync.RWMutex
+	*Classifier
+}
+
+func init() {
+	classifier, err := NewClassifier(DefaultClassifierRules)
+	if err != nil {
+		panic(err)
+	}
+	SetClassifier(classifier)
+}
+
+// Compile the rules.
+func NewClassifier(rules []ClassifierRule) (*Classifier, error) {
+	compiled := make([]ClassifierRule, len(rules))
+	for i, rule := range rules {
+		if rule.Category == "" {
+			return nil, fmt.Errorf("Classifier rule %d has no category", i+1)
+		}
+
+		if rule.Pattern != "" {
+			var err error
+			rule.pattern, err = regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Classifier rule %q: %v", rule.Category, err)
+			}
+		}
+
+		rule.status = Failed
+		if rule.Status != "" {
+			status, err := ParseDeployStatus(rule.Status)
+			if err != nil {
+				return nil, fmt.Errorf("Classifier rule %q: %v", rule.Category, err)
+			}
+			if !status.Finished() {
+				return nil, fmt.Errorf("Classifier rule %q: status must be finished, not %s",
+					rule.Category, status)
+			}
+			rule.status = status
+		}
+
+		compiled[i] = rule
+	}
+
+	return &Classifier{Rules: compiled}, nil
+}
+
+// Use a classifier for all new deploys.
+func SetClassifier(classifier *Classifier) {
+	currentClassifier.Lock()
+	defer currentClassifier.Unlock()
+	currentClassifier.Classifier = classifier
+}
+
+func (rule *ClassifierRule) Match(deployError *DeployError) bool {
+	if rule.Kind != "" && rule.Kind != deployError.Kind {
+		return false
+	}
+
+	if rule.pattern != nil && !rule.pattern.MatchString(deployError.Msg) {
+		return false
+	}
+
+	return true
+}
+
+// Set the category (and possibly the status) of a failed deploy. Returns true
+// if the deploy was changed.
+func (classifier *Classifier) Classify(deploy *Deploy) bool {
+	if deploy.Status != Failed {
+		return false
+	}
+
+	deploy.Category = OtherCategory
+	if deploy.Error == nil {
+		return false
+	}
+
+	for i := range classifier.Rules {
+		rule := &classifier.Rules[i]
+		if rule.Match(deploy.Error) {
+			deploy.Category = rule.Category
+			deploy.Status = rule.status
+			return true
+		}
+	}
+
+	return false
+}
+
+// Categorize a failed deploy with the current classifier. This can turn a
+// failure into a deletion.
+func (deploy *Deploy) Classify() {
+	currentClassifier.RLock()
+	defer currentClassifier.RUnlock()
+	currentClassifier.Classify(deploy)
+}
+
+// Classify a deploy again, e.g. after the rules have changed. Deploys that were
+// turned into deletions by a rule are treated as failures again first.
+func (deploy *Deploy) Reclassify() {
+	if deploy.Category != "" && deploy.Error != nil && deploy.Status.Finished() {
+		deploy.Status = Failed
+	}
+	deploy.Category = ""
+	deploy.Classify()
+}
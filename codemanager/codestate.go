@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -15,70 +17,196 @@ type CodeState struct {
 
 const RFC3339Micro = "2006-01-02T15:04:05.999Z07:00"
 
-func LoadCodeState(path string) (CodeState, error) {
-	log.Tracef("LoadCodeState(%q)", path)
-	state := CodeState{}
+// The contents of a JSON state file. The default server's environments are at
+// the top level, as they were before multiple servers were supported; other
+// servers are stored under Servers.
+type stateFile struct {
+	CodeState
+	Servers map[string]*CodeState `json:",omitempty"`
+}
+
+func loadStateFile(path string) (stateFile, error) {
+	log.Tracef("loadStateFile(%q)", path)
+	file := stateFile{}
 
 	stateJson, err := ioutil.ReadFile(path)
 	if err != nil {
-		return state, err
+		return file, err
+	}
+
+	err = json.Unmarshal(stateJson, &file)
+	return file, err
+}
+
+// Like loadStateFile, but a missing file is treated as an empty state.
+func loadOptionalStateFile(path string) (stateFile, error) {
+	file, err := loadStateFile(path)
+	if os.IsNotExist(err) {
+		return file, nil
+	}
+
+	return file, err
+}
+
+// The state of a server. The default server is "".
+func (file *stateFile) server(name string) CodeState {
+	if name == "" {
+		return file.CodeState
+	}
+
+	if codeState := file.Servers[name]; codeState != nil {
+		return *codeState
+	}
+
+	return CodeState{}
+}
+
+func (file *stateFile) setServer(name string, codeState CodeState) {
+	if name == "" {
+		file.CodeState = codeState
+		return
+	}
+
+	if file.Servers == nil {
+		file.Servers = map[string]*CodeState{}
+	}
+
+	if len(codeState.Environments) == 0 {
+		delete(file.Servers, name)
+	} else {
+		file.Servers[name] = &codeState
+	}
+}
+
+// Names of the servers with environments in the file, sorted.
+func (file *stateFile) serverNames() []string {
+	names := []string{}
+	if len(file.Environments) > 0 {
+		names = append(names, "")
+	}
+
+	for name := range file.Servers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Load the state of a server from a JSON file. The default server is "".
+func LoadCodeState(path string, server string) (CodeState, error) {
+	file, err := loadStateFile(path)
+	return file.server(server), err
+}
+
+// A deep copy of the state that isn't tied to the store it was loaded from, so
+// it can be saved to a different store or server.
+func (codeState *CodeState) Clone() (CodeState, error) {
+	clone := CodeState{}
+	stateJson, err := json.Marshal(codeState)
+	if err != nil {
+		return clone, err
 	}
 
-	err = json.Unmarshal(stateJson, &state)
-	return state, err
+	err = json.Unmarshal(stateJson, &clone)
+	return clone, err
 }
 
-func SaveCodeState(codeState *CodeState, path string) error {
-	log.Tracef("SaveCodeState(<>, %q)", path)
-	stateJson, err := json.MarshalIndent(*codeState, "", "  ")
+func saveStateFile(file *stateFile, path string) error {
+	log.Tracef("saveStateFile(<>, %q)", path)
+	stateJson, err := json.MarshalIndent(*file, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	/// FIXME should we lock this?
-	return ioutil.WriteFile(path, append(stateJson, '\n'), 0644)
+	// Callers that load, modify, and save should use UpdateCodeState, which
+	// locks the file.
+	return writeFileAtomic(path, append(stateJson, '\n'), 0644)
 }
 
-func (codeState *CodeState) UpdateFromRawCodeState(rawCodeState JsonObject) {
-	log.Debug("CodeState<>.UpdateFromRawCodeState(<>)")
+// Write to a temporary file in the same directory, then rename it over path so
+// that readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
 
-	newDeploys := map[string][]Deploy{}
+	tempPath := file.Name()
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, mode)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
 
-	deploysStatus := rawCodeState.GetObject("deploys-status")
-	mappings := map[string]DeployStatus{
-		"new":       New,
-		"queued":    Queued,
-		"deploying": Deploying,
-		"failed":    Failed,
+	if err != nil {
+		os.Remove(tempPath)
 	}
 
-	for key, status := range mappings {
-		rawDeploys := deploysStatus.GetArray(key)
-		convertRawDeploys(rawDeploys, status, &newDeploys)
+	return err
+}
+
+// Merge a status update into the state, and return the status changes that
+// resulted. The update is validated before the state is changed, so if this
+// returns an error the state is untouched.
+func (codeState *CodeState) UpdateFromRawCodeState(rawCodeState *RawCodeState) ([]Transition, error) {
+	log.Debug("CodeState<>.UpdateFromRawCodeState(<>)")
+
+	err := rawCodeState.Validate()
+	if err != nil {
+		return nil, err
 	}
 
-	fileSyncStatus := rawCodeState.GetObject("file-sync-storage-status")
-	rawDeploys := fileSyncStatus.GetArray("deployed")
-	convertRawDeploys(rawDeploys, Deployed, &newDeploys)
+	newDeploys := map[string][]Deploy{}
+	observedAt := time.Now()
+
+	deploysStatus := rawCodeState.DeploysStatus
+	convertRawDeploys(deploysStatus.New, New, newDeploys)
+	convertRawDeploys(deploysStatus.Queued, Queued, newDeploys)
+	convertRawDeploys(deploysStatus.Deploying, Deploying, newDeploys)
+	convertRawDeploys(deploysStatus.Failed, Failed, newDeploys)
+
+	fileSyncStatus := rawCodeState.FileSyncStorageStatus
+	convertRawDeploys(fileSyncStatus.Deployed, Deployed, newDeploys)
+
+	// Code Manager doesn't report when deploys start, so use the first time we
+	// see them deploying.
+	for _, deploys := range newDeploys {
+		for i := range deploys {
+			if deploys[i].Status == Deploying {
+				deploys[i].StartedAt = observedAt
+			}
+		}
+	}
 
+	transitions := []Transition{}
 	environmentsSeen := map[string]bool{}
 	for name, environmentState := range codeState.Environments {
 		environmentState.SortDeploys(Descending)
 
 		environmentsSeen[name] = true
 		if newDeploys[name] != nil {
-			environmentState.AddDeploys(newDeploys[name])
-		} else if environmentState.Deploys[0].Status != Deleted {
+			transitions = append(transitions,
+				environmentState.AddDeploys(newDeploys[name])...)
+		} else if latest := environmentState.Latest(); latest == nil || latest.Status != Deleted {
 			log.Debugf("Environment %q not in the latest status update", name)
 			// This environment wasn't in the current update, and its last recorded
 			// status isn't Deleted. So, it needs a Deleted record.
-			environmentState.AddDeploys([]Deploy{
+			transitions = append(transitions, environmentState.AddDeploys([]Deploy{
 				Deploy{
 					Environment:   name,
 					Status:        Deleted,
 					EstimatedTime: time.Now(),
 				},
-			})
+			})...)
 		}
 	}
 
@@ -93,54 +221,59 @@ func (codeState *CodeState) UpdateFromRawCodeState(rawCodeState JsonObject) {
 		}
 
 		newEnvironmentState := EnvironmentState{Environment: name}
-		newEnvironmentState.AddDeploys(deploys)
+		added := newEnvironmentState.AddDeploys(deploys)
 		codeState.Environments[name] = &newEnvironmentState
-	}
-}
-
-func convertRawDeploys(rawDeploys []interface{}, status DeployStatus, environments *map[string][]Deploy) {
-	log.Debug("convertRawDeploys ", len(rawDeploys), " ", status, " deploys")
-	for _, _rawDeploy := range rawDeploys {
-		rawDeploy := JsonObject(_rawDeploy.(map[string]interface{}))
-		deploy := convertRawDeploy(rawDeploy, status)
 
-		deploys := (*environments)[deploy.Environment]
-		(*environments)[deploy.Environment] = append(deploys, deploy)
-	}
-}
+		// Record history for every deploy, but only report the environment once.
+		for i := range added {
+			added[i].ObservedAt = observedAt
+			added[i].record()
+		}
 
-func convertRawDate(rawDate interface{}) time.Time {
-	if rawDate == nil {
-		return time.Time{}
+		latest := newEnvironmentState.SortedDeploys(Descending)[0]
+		transition := newTransition(New, latest)
+		transition.NewDeploy = true
+		transition.NewEnvironment = true
+		transitions = append(transitions, transition)
 	}
 
-	date, err := time.Parse(RFC3339Micro, rawDate.(string))
-	if err != nil {
-		log.Fatal(err)
+	for i := range transitions {
+		transitions[i].ObservedAt = observedAt
+		transitions[i].record()
 	}
+	sort.SliceStable(transitions, func(i, j int) bool {
+		return transitions[i].Environment < transitions[j].Environment
+	})
 
-	return date
+	codeState.updateCompilers(rawCodeState.FileSyncClientStatus)
+	return transitions, nil
 }
 
-func convertRawDeploy(rawDeploy JsonObject, status DeployStatus) Deploy {
-	var deploy Deploy
+// Record deploys that we requested ourselves. These are added without matching
+// them against existing deploys.
+func (codeState *CodeState) AddRequestedDeploys(deploys []Deploy) {
+	if codeState.Environments == nil {
+		codeState.Environments = map[string]*EnvironmentState{}
+	}
 
-	deploy.Environment = rawDeploy["environment"].(string)
-	deploy.Status = status
-	deploy.QueuedAt = convertRawDate(rawDeploy["queued-at"])
-	deploy.FinishedAt = convertRawDate(rawDeploy["date"])
+	for i := range deploys {
+		deploy := deploys[i]
+		environmentState := codeState.Environments[deploy.Environment]
+		if environmentState == nil {
+			environmentState = &EnvironmentState{Environment: deploy.Environment}
+			codeState.Environments[deploy.Environment] = environmentState
+		}
 
-	if rawDeploy["deploy-signature"] != nil {
-		deploy.Sha = rawDeploy["deploy-signature"].(string)
+		environmentState.Deploys = append(environmentState.Deploys, &deploy)
 	}
+}
 
-	if rawDeploy["error"] != nil {
-		deploy.Error = rawDeploy.GetObject("error")
+func convertRawDeploys(rawDeploys []RawDeploy, status DeployStatus, environments map[string][]Deploy) {
+	log.Debug("convertRawDeploys ", len(rawDeploys), " ", status, " deploys")
+	for _, rawDeploy := range rawDeploys {
+		deploy := rawDeploy.Deploy(status)
+		environments[deploy.Environment] = append(environments[deploy.Environment], deploy)
 	}
-
-	deploy.CorrectFailedStatus()
-
-	return deploy
 }
 
 func (codeState *CodeState) SortedEnvironments() []*EnvironmentState {
@@ -152,9 +285,9 @@ func (codeState *CodeState) SortedEnvironments() []*EnvironmentState {
 	}
 
 	sort.Slice(environments, func(i, j int) bool {
-		a := environments[i].Deploys[0]
-		b := environments[j].Deploys[0]
-		return strings.ToLower(a.Environment) < strings.ToLower(b.Environment)
+		a := environments[i].Environment
+		b := environments[j].Environment
+		return strings.ToLower(a) < strings.ToLower(b)
 	})
 
 	return environments
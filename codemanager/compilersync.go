@@ -0,0 +1,133 @@
+package codemanager
+
+import (
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"time"
+)
+
+// The state of one environment on one compiler (file sync client)
+type CompilerSync struct {
+	Name        string
+	Sha         string    // Empty if the compiler doesn't have the environment
+	DeployedAt  time.Time // When the compiler's copy was deployed
+	CheckedInAt time.Time // When the compiler last checked in with storage
+}
+
+// Has the compiler synced this deploy?
+func (deploy *Deploy) SyncedTo(compiler *CompilerSync) bool {
+	return deploy.Sha != "" && compiler.Sha == deploy.Sha
+}
+
+// Compilers that don't have this deploy yet. Only meaningful for the latest
+// deploy; see EnvironmentState.LaggingCompilers().
+func (deploy *Deploy) LaggingCompilers() []CompilerSync {
+	lagging := []CompilerSync{}
+	for _, compiler := range deploy.Compilers {
+		if !deploy.SyncedTo(&compiler) {
+			lagging = append(lagging, compiler)
+		}
+	}
+
+	return lagging
+}
+
+// The most recent successful deploy, or nil. Deploys are compared by when they
+// finished, since a deploy we only have a finish time for can finish before a
+// later deploy that was queued earlier.
+func (environmentState *EnvironmentState) LatestDeployed() *Deploy {
+	var latest *Deploy
+	for _, deploy := range environmentState.Deploys {
+		if deploy.Status != Deployed {
+			continue
+		}
+
+		if latest == nil || deploy.FinishedAt.After(latest.FinishedAt) {
+			latest = deploy
+		}
+	}
+
+	return latest
+}
+
+// The SHA of the most recent successful deploy of an environment, or "".
+func (codeState *CodeState) DeployedSha(environment string) string {
+	environmentState := codeState.Environments[environment]
+	if environmentState == nil {
+		return ""
+	}
+
+	deploy := environmentState.LatestDeployed()
+	if deploy == nil {
+		return ""
+	}
+
+	return deploy.Sha
+}
+
+// Compilers that haven't synced the current code for the environment
+func (environmentState *EnvironmentState) LaggingCompilers() []CompilerSync {
+	deploy := environmentState.LatestDeployed()
+	if deploy == nil {
+		return []CompilerSync{}
+	}
+
+	return deploy.LaggingCompilers()
+}
+
+// Record the file sync status of each compiler on the latest successful deploy
+// of each environment. Older deploys keep the status they had when they were
+// superseded.
+func (codeState *CodeState) updateCompilers(clientStatus *RawFileSyncClientStatus) {
+	if clientStatus == nil {
+		return
+	}
+
+	names := make([]string, 0, len(clientStatus.Clients))
+	checkIns := map[string]time.Time{}
+	// environment => compiler name => status
+	environments := map[string]map[string]CompilerSync{}
+
+	for name, client := range clientStatus.Clients {
+		names = append(names, name)
+		checkIns[name], _ = parseRawDate("last_check_in_time", client.LastCheckInTime)
+
+		for _, rawDeploy := range client.Deployed {
+			deploy := rawDeploy.Deploy(Deployed)
+			if environments[deploy.Environment] == nil {
+				environments[deploy.Environment] = map[string]CompilerSync{}
+			}
+
+			environments[deploy.Environment][name] = CompilerSync{
+				Name:        name,
+				Sha:         deploy.Sha,
+				DeployedAt:  deploy.FinishedAt,
+				CheckedInAt: checkIns[name],
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	for name, environmentState := range codeState.Environments {
+		deploy := environmentState.LatestDeployed()
+		if deploy == nil {
+			continue
+		}
+
+		deploy.Compilers = make([]CompilerSync, len(names))
+		for i, compilerName := range names {
+			compiler, ok := environments[name][compilerName]
+			if !ok {
+				// The compiler doesn't have the environment at all.
+				compiler = CompilerSync{
+					Name:        compilerName,
+					CheckedInAt: checkIns[compilerName],
+				}
+			}
+			deploy.Compilers[i] = compiler
+		}
+
+		log.Tracef("%s: %d compilers lagging", name, len(deploy.LaggingCompilers()))
+	}
+}
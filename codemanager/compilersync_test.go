@@ -0,0 +1,64 @@
+package codemanager
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func loadCorpus(t *testing.T, pattern string) CodeState {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join("..", "corpus", pattern))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no files match corpus/%s", pattern)
+	}
+
+	var codeState CodeState
+	for _, path := range paths {
+		rawJson, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rawCodeState, err := DecodeRawCodeState(path, rawJson)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := codeState.UpdateFromRawCodeState(rawCodeState); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+
+	return codeState
+}
+
+// A deploy with only a finish time can sort below a later deploy that has a
+// queued time; the later deploy is still the one compilers should be compared
+// against.
+func TestLatestDeployedByFinishTime(t *testing.T) {
+	codeState := loadCorpus(t, "small/*.json")
+
+	environmentState := codeState.Environments["combined_minor_changes"]
+	if environmentState == nil {
+		t.Fatal("combined_minor_changes missing from corpus")
+	}
+
+	const sha = "09f2520f56cbfa415d0310908a37009eecfa202a"
+	deploy := environmentState.LatestDeployed()
+	if deploy == nil || deploy.Sha != sha {
+		t.Fatalf("LatestDeployed() = %+v, want deploy of %s", deploy, sha)
+	}
+
+	if len(deploy.Compilers) == 0 {
+		t.Error("latest deploy has no compiler status")
+	}
+
+	if lagging := environmentState.LaggingCompilers(); len(lagging) != 0 {
+		t.Errorf("%d compilers lagging, want 0", len(lagging))
+	}
+}
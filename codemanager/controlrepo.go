@@ -0,0 +1,332 @@
+package codemanager
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Don't fetch from the control repo's remote more often than this when looking
+// for unknown commits.
+const ControlRepoFetchInterval = time.Minute
+
+// Don't look for a commit that couldn't be found again for this long, unless
+// the repo is fetched first.
+const ControlRepoMissTime = time.Minute
+
+// A commit in the control repo
+type CommitInfo struct {
+	Sha     string
+	Subject string
+	Author  string
+	Date    time.Time
+}
+
+// How far one commit is from another
+type Comparison struct {
+	Ahead  int // Commits in the compared commit but not the base
+	Behind int // Commits in the base but not the compared commit
+}
+
+// Looks up commits in a local git clone of the control repo. Results are
+// cached, since commits don't change. Failed lookups are cached for
+// ControlRepoMissTime, or until the next fetch.
+//
+// Lookups never fetch; call Refresh in the background to fetch missing commits.
+type ControlRepo struct {
+	Path string
+
+	lock        sync.Mutex
+	commits     map[string]*CommitInfo
+	comparisons map[string]*Comparison
+	misses      map[string]time.Time // Commit or comparison key => when it failed
+
+	fetchLock sync.Mutex
+	lastFetch time.Time
+}
+
+var shaPattern = regexp.MustCompile(`^[0-9a-f]{4,40}$`)
+
+func NewControlRepo(path string) (*ControlRepo, error) {
+	repo := &ControlRepo{
+		Path:        path,
+		commits:     map[string]*CommitInfo{},
+		comparisons: map[string]*Comparison{},
+		misses:      map[string]time.Time{},
+	}
+
+	_, err := repo.git("rev-parse", "--git-dir")
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+func (repo *ControlRepo) git(args ...string) (string, error) {
+	args = append([]string{"-C", repo.Path}, args...)
+	log.Tracef("git %s", strings.Join(args, " "))
+
+	var stderr bytes.Buffer
+	command := exec.Command("git", args...)
+	command.Stderr = &stderr
+	output, err := command.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err,
+			strings.TrimSpace(stderr.String()))
+	}
+
+	return string(output), nil
+}
+
+// Make sure a commit is present. Must be called with the lock held.
+func (repo *ControlRepo) ensureCommit(sha string) error {
+	if !shaPattern.MatchString(sha) {
+		return fmt.Errorf("Invalid commit SHA %q", sha)
+	}
+
+	_, err := repo.git("cat-file", "-e", sha+"^{commit}")
+	return err
+}
+
+// Did looking up key fail recently? Must be called with the lock held.
+func (repo *ControlRepo) missed(key string) bool {
+	failedAt, found := repo.misses[key]
+	if !found {
+		return false
+	}
+
+	if time.Since(failedAt) >= ControlRepoMissTime {
+		delete(repo.misses, key)
+		return false
+	}
+
+	return true
+}
+
+// Look up commits, and if any can't be found, fetch from the control repo's
+// remote (at most once every ControlRepoFetchInterval). Fetching can be slow,
+// so this shouldn't be called while handling a request.
+func (repo *ControlRepo) Refresh(shas []string) {
+	if repo == nil {
+		return
+	}
+
+	repo.fetchLock.Lock()
+	defer repo.fetchLock.Unlock()
+
+	missing := 0
+	for _, sha := range shas {
+		if !shaPattern.MatchString(sha) {
+			continue
+		}
+
+		if repo.Commit(sha) == nil {
+			missing++
+		}
+	}
+
+	if missing == 0 || time.Since(repo.lastFetch) < ControlRepoFetchInterval {
+		return
+	}
+
+	log.Debugf("%d commits not found in %s; fetching", missing, repo.Path)
+	repo.lastFetch = time.Now()
+	if _, err := repo.git("fetch", "--quiet", "--all"); err != nil {
+		log.Warn(err)
+		return
+	}
+
+	// Try again with the fetched commits.
+	repo.lock.Lock()
+	repo.misses = map[string]time.Time{}
+	repo.lock.Unlock()
+
+	for _, sha := range shas {
+		repo.Commit(sha)
+	}
+}
+
+// Look up a commit. Returns nil if the commit can't be found.
+func (repo *ControlRepo) Commit(sha string) *CommitInfo {
+	if repo == nil || sha == "" {
+		return nil
+	}
+
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+
+	if commit, found := repo.commits[sha]; found {
+		return commit
+	}
+
+	if repo.missed(sha) {
+		return nil
+	}
+
+	commit, err := repo.lookupCommit(sha)
+	if err != nil {
+		log.Debugf("Looking up commit %s: %v", sha, err)
+		repo.misses[sha] = time.Now()
+		return nil
+	}
+
+	repo.commits[sha] = commit
+	return commit
+}
+
+// Look up a commit without running git. Returns nil unless the commit has
+// already been found.
+func (repo *ControlRepo) CachedCommit(sha string) *CommitInfo {
+	if repo == nil {
+		return nil
+	}
+
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	return repo.commits[sha]
+}
+
+func (repo *ControlRepo) lookupCommit(sha string) (*CommitInfo, error) {
+	err := repo.ensureCommit(sha)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := repo.git("log", "-1", "--format=%H%x00%an%x00%aI%x00%s", sha)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.SplitN(strings.TrimRight(output, "\n"), "\x00", 4)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("Unexpected git log output %q", output)
+	}
+
+	date, err := time.Parse(time.RFC3339, fields[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return &CommitInfo{
+		Sha:     fields[0],
+		Author:  fields[1],
+		Date:    date,
+		Subject: fields[3],
+	}, nil
+}
+
+// Compare a commit to a base commit. Returns nil if either commit can't be
+// found.
+func (repo *ControlRepo) Compare(sha string, base string) *Comparison {
+	if repo == nil || sha == "" || base == "" {
+		return nil
+	}
+
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+
+	key := base + "..." + sha
+	if comparison, found := repo.comparisons[key]; found {
+		return comparison
+	}
+
+	if repo.missed(key) {
+		return nil
+	}
+
+	comparison, err := repo.compare(sha, base)
+	if err != nil {
+		log.Debugf("Comparing %s: %v", key, err)
+		repo.misses[key] = time.Now()
+		return nil
+	}
+
+	repo.comparisons[key] = comparison
+	return comparison
+}
+
+// Compare a commit to a base commit without running git. Returns nil unless
+// the comparison has already been made.
+func (repo *ControlRepo) CachedCompare(sha string, base string) *Comparison {
+	if repo == nil {
+		return nil
+	}
+
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	return repo.comparisons[base+"..."+sha]
+}
+
+func (repo *ControlRepo) compare(sha string, base string) (*Comparison, error) {
+	for _, commit := range []string{sha, base} {
+		if err := repo.ensureCommit(commit); err != nil {
+			return nil, err
+		}
+	}
+
+	output, err := repo.git("rev-list", "--left-right", "--count", base+"..."+sha)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("Unexpected git rev-list output %q", output)
+	}
+
+	behind, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	ahead, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Comparison{Ahead: ahead, Behind: behind}, nil
+}
+
+func (comparison Comparison) String() string {
+	if comparison.Ahead == 0 && comparison.Behind == 0 {
+		return "up to date"
+	}
+	return fmt.Sprintf("%d ahead, %d behind", comparison.Ahead, comparison.Behind)
+}
+
+// The SHAs of the environment's deploys, without duplicates.
+func (environmentState *EnvironmentState) Shas() []string {
+	seen := map[string]bool{}
+	shas := []string{}
+	for _, deploy := range environmentState.Deploys {
+		if deploy.Sha != "" && !seen[deploy.Sha] {
+			seen[deploy.Sha] = true
+			shas = append(shas, deploy.Sha)
+		}
+	}
+
+	return shas
+}
+
+// The SHAs of every deploy, without duplicates.
+func (codeState *CodeState) Shas() []string {
+	seen := map[string]bool{}
+	shas := []string{}
+	for _, environmentState := range codeState.Environments {
+		for _, sha := range environmentState.Shas() {
+			if !seen[sha] {
+				seen[sha] = true
+				shas = append(shas, sha)
+			}
+		}
+	}
+
+	return shas
+}
@@ -3,7 +3,6 @@ package codemanager
 import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
-	"strings"
 	"time"
 )
 
@@ -14,26 +13,32 @@ type Deploy struct {
 	FinishedAt    time.Time
 	QueuedAt      time.Time
 	EstimatedTime time.Time
-	Error         JsonObject
-}
+	// When we first saw the deploy in the deploying state. Approximate.
+	StartedAt time.Time
+	Error     *DeployError
 
-func (deploy *Deploy) CorrectFailedStatus() bool {
-	// If the status is Failed and the error message contains the below, then it
-	// actually the represents environment being deleted.
-	const deletionMsg = "cannot be found in any source and will not be deployed."
+	// Why the deploy failed, as determined by a Classifier
+	Category string `json:",omitempty"`
 
-	if deploy.Status == Failed && deploy.Error != nil && deploy.Error["msg"] != nil {
-		msg := deploy.Error["msg"].(string)
+	// This deploy was started by us, so QueuedAt and FinishedAt are only
+	// approximate until it's matched with a record from the status API.
+	Requested bool `json:",omitempty"`
 
-		if strings.Contains(msg, deletionMsg) {
-			deploy.Status = Deleted
-			return true
-		}
-	}
+	// Statuses we've seen the deploy in, oldest first. Deploys recorded before
+	// this was added may have incomplete histories.
+	History []StatusChange `json:",omitempty"`
 
-	return deploy.Status == Deleted
+	// File sync status on each compiler. Only updated while this is the latest
+	// successful deploy of the environment.
+	Compilers []CompilerSync `json:",omitempty"`
+
+	// Row ID used by SqliteStore
+	storeId int64
 }
 
+// How far a Requested deploy's times may be from the times Code Manager reports.
+const requestedSlop = time.Minute
+
 func (deploy *Deploy) DisplayTime() time.Time {
 	// We really care about when it was finished.
 	if deploy.HasFinishedTime() {
@@ -64,10 +69,44 @@ func (deploy *Deploy) HasFinishedTime() bool {
 	return deploy.FinishedAt.After(time.Time{})
 }
 
+func (deploy *Deploy) HasStartedTime() bool {
+	return deploy.StartedAt.After(time.Time{})
+}
+
 func (deploy *Deploy) HasEstimatedTime() bool {
 	return deploy.EstimatedTime.After(time.Time{})
 }
 
+// Time between two times, or 0 if either is unknown or they're out of order.
+func durationBetween(start time.Time, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// Time from being queued to starting to deploy, or 0 if unknown.
+func (deploy *Deploy) QueueWait() time.Duration {
+	if deploy.Requested {
+		// QueuedAt is approximate.
+		return 0
+	}
+	return durationBetween(deploy.QueuedAt, deploy.StartedAt)
+}
+
+// Time from starting to deploy to finishing, or 0 if unknown.
+func (deploy *Deploy) DeployTime() time.Duration {
+	return durationBetween(deploy.StartedAt, deploy.FinishedAt)
+}
+
+// Time from being queued to finishing, or 0 if unknown.
+func (deploy *Deploy) TotalTime() time.Duration {
+	if deploy.Requested {
+		return 0
+	}
+	return durationBetween(deploy.QueuedAt, deploy.FinishedAt)
+}
+
 func (deploy *Deploy) String() string {
 	return fmt.Sprintf("%s %s (%s)", deploy.Environment, deploy.Status, deploy.MatchTime())
 }
@@ -95,6 +134,10 @@ func (a *Deploy) Match(b *Deploy) Trinary {
 		return No
 	}
 
+	if a.Requested {
+		return a.matchRequested(b)
+	}
+
 	time0 := time.Time{}
 	sameTime := false
 	debugTimeMatchString := ""
@@ -157,6 +200,37 @@ func (a *Deploy) Match(b *Deploy) Trinary {
 	return Maybe
 }
 
+// Match a deploy we requested, which has approximate times, with an updated
+// record from Code Manager.
+func (a *Deploy) matchRequested(b *Deploy) Trinary {
+	if a.Status.Finished() && !b.Status.Finished() {
+		log.Tracef("Match No: requested finished (%s) can't be updated with unfinished (%s)", a.Status, b.Status)
+		return No
+	}
+
+	if a.Sha != "" && a.Sha == b.Sha && a.Status == b.Status {
+		log.Tracef("Match Yes: requested with same status (%s) and sha %s", a.Status, a.Sha)
+		return Yes
+	}
+
+	if b.HasQueuedTime() {
+		difference := a.QueuedAt.Sub(b.QueuedAt)
+		if difference < requestedSlop && difference > -requestedSlop {
+			log.Tracef("Match Yes: requested at %s, queued at %s", a.QueuedAt, b.QueuedAt)
+			return Yes
+		}
+	}
+
+	if !a.Status.Finished() && b.Status.Finished() &&
+		b.MatchTime().After(a.QueuedAt.Add(-requestedSlop)) {
+		log.Tracef("Match Maybe: requested %s, finished %s", a, b)
+		return Maybe
+	}
+
+	log.Tracef("Match No: requested %s, got %s", a, b)
+	return No
+}
+
 func (deploy *Deploy) Update(newDeploy *Deploy) {
 	log.Debugf("Updating %q deploy from %s to %s",
 		deploy.Environment, deploy.Status, newDeploy.Status)
@@ -170,6 +244,10 @@ func (deploy *Deploy) Update(newDeploy *Deploy) {
 		deploy.EstimatedTime = newDeploy.EstimatedTime
 	}
 
+	if newDeploy.HasStartedTime() && !deploy.HasStartedTime() {
+		deploy.StartedAt = newDeploy.StartedAt
+	}
+
 	if newDeploy.Sha != "" {
 		deploy.Sha = newDeploy.Sha
 	}
@@ -177,4 +255,22 @@ func (deploy *Deploy) Update(newDeploy *Deploy) {
 	if newDeploy.Error != nil {
 		deploy.Error = newDeploy.Error
 	}
+
+	if newDeploy.Category != "" {
+		deploy.Category = newDeploy.Category
+	}
+
+	if deploy.Requested && newDeploy.HasQueuedTime() {
+		// Now we know when Code Manager actually queued it.
+		deploy.QueuedAt = newDeploy.QueuedAt
+		deploy.Requested = false
+	}
+}
+
+// Abbreviate a SHA for display. Shorter SHAs are returned unchanged.
+func ShortSha(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
 }
@@ -0,0 +1,81 @@
+package codemanager
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type deployRequest struct {
+	Environments []string `json:"environments,omitempty"`
+	DeployAll    bool     `json:"deploy-all,omitempty"`
+	Wait         bool     `json:"wait"`
+}
+
+// One entry in the response from the deploys endpoint
+type DeployResult struct {
+	Environment string       `json:"environment"`
+	Id          int          `json:"id"`
+	Status      string       `json:"status"`
+	Sha         string       `json:"deploy-signature"`
+	Error       *DeployError `json:"error"`
+}
+
+// Ask Code Manager to deploy environments, or all environments if all is true.
+// If wait is true, this won't return until the deploys have finished.
+func (client *ApiClient) Deploy(environments []string, all bool, wait bool) ([]DeployResult, error) {
+	results := []DeployResult{}
+
+	requestJson, err := json.Marshal(deployRequest{
+		Environments: environments,
+		DeployAll:    all,
+		Wait:         wait,
+	})
+	if err != nil {
+		return results, err
+	}
+
+	path := "/code-manager/v1/deploys"
+	body, err := client.request("POST", path, requestJson)
+	if err != nil {
+		return results, err
+	}
+
+	err = json.Unmarshal(body, &results)
+	if err != nil {
+		return results, &DecodeError{Source: client.url(path), Err: err}
+	}
+
+	return results, nil
+}
+
+// Convert a result into a Deploy record. requestedAt should be the time the
+// deploy was requested.
+func (result *DeployResult) Deploy(requestedAt time.Time) Deploy {
+	deploy := Deploy{
+		Environment: result.Environment,
+		Status:      Queued,
+		Sha:         result.Sha,
+		QueuedAt:    requestedAt,
+		Requested:   true,
+		History:     []StatusChange{{Queued, requestedAt}},
+	}
+
+	switch result.Status {
+	case "complete":
+		deploy.Status = Deployed
+		deploy.FinishedAt = time.Now()
+	case "failed":
+		deploy.Status = Failed
+		deploy.FinishedAt = time.Now()
+	}
+
+	deploy.Error = result.Error
+	deploy.Classify()
+
+	if deploy.Status != Queued {
+		deploy.History = append(deploy.History,
+			StatusChange{deploy.Status, deploy.FinishedAt})
+	}
+
+	return deploy
+}
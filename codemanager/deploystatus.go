@@ -29,7 +29,7 @@ var DeployStatusNames = [...]string{
 }
 
 /// FIXME needs testing
-func stringToDeployStatus(status string) (DeployStatus, error) {
+func ParseDeployStatus(status string) (DeployStatus, error) {
 	for index, name := range DeployStatusNames {
 		if name == status {
 			return DeployStatus(index), nil
@@ -54,7 +54,7 @@ func (status *DeployStatus) UnmarshalJSON(raw []byte) error {
 		return err
 	}
 
-	_status, err := stringToDeployStatus(rawString)
+	_status, err := ParseDeployStatus(rawString)
 	if err != nil {
 		return err
 	}
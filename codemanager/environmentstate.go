@@ -17,12 +17,24 @@ const (
 	Descending = iota
 )
 
-func (environmentState *EnvironmentState) AddDeploys(newDeploys []Deploy) {
+// Merge new deploy records into the environment, and return the status changes
+// that resulted.
+func (environmentState *EnvironmentState) AddDeploys(newDeploys []Deploy) []Transition {
 	log.Debugf("%s AddDeploys([%d]Deploy)",
 		environmentState.Environment,
 		len(newDeploys))
 
 	deploysToAdd := []*Deploy{}
+	transitions := []Transition{}
+
+	// Record a transition if an old deploy's status changes when it's updated.
+	update := func(oldDeploy *Deploy, newDeploy *Deploy) {
+		from := oldDeploy.Status
+		oldDeploy.Update(newDeploy)
+		if oldDeploy.Status != from {
+			transitions = append(transitions, newTransition(from, oldDeploy))
+		}
+	}
 
 	environmentState.SortDeploys(Descending)
 	oldDeploysMatched := make(map[int]bool, len(environmentState.Deploys))
@@ -44,7 +56,7 @@ func (environmentState *EnvironmentState) AddDeploys(newDeploys []Deploy) {
 
 			match := oldDeploy.Match(newDeploy)
 			if match == Yes {
-				oldDeploy.Update(newDeploy)
+				update(oldDeploy, newDeploy)
 				oldDeploysMatched[i] = true
 				found = true
 				break
@@ -60,23 +72,29 @@ func (environmentState *EnvironmentState) AddDeploys(newDeploys []Deploy) {
 
 		if possibleMatch >= 0 {
 			log.Tracef("Using possible match")
-			environmentState.Deploys[possibleMatch].Update(newDeploy)
+			update(environmentState.Deploys[possibleMatch], newDeploy)
 			oldDeploysMatched[possibleMatch] = true
 			continue
 		}
 
 		// It's new
 		deploysToAdd = append(deploysToAdd, newDeploy)
+		transition := newTransition(New, newDeploy)
+		transition.NewDeploy = true
+		transitions = append(transitions, transition)
 	}
 
 	for i, oldDeploy := range environmentState.Deploys {
 		if !oldDeploysMatched[i] && !oldDeploy.Status.Finished() {
 			log.Tracef("Found ghost deploy")
-			environmentState.Deploys[i].Status = Ghost
+			from := oldDeploy.Status
+			oldDeploy.Status = Ghost
+			transitions = append(transitions, newTransition(from, oldDeploy))
 		}
 	}
 
 	environmentState.Deploys = append(environmentState.Deploys, deploysToAdd...)
+	return transitions
 }
 
 func _sortDeploys(deploys []*Deploy, order SortOrder) {
@@ -95,8 +113,22 @@ func (environmentState *EnvironmentState) SortDeploys(order SortOrder) {
 	_sortDeploys(environmentState.Deploys, order)
 }
 
-// Convenience, consistency, clarity
+// Get the deploys in order. This returns a new slice and does not reorder
+// environmentState.Deploys, so it's safe to call while holding a read lock.
 func (environmentState *EnvironmentState) SortedDeploys(order SortOrder) []*Deploy {
-	_sortDeploys(environmentState.Deploys, order)
-	return environmentState.Deploys
+	deploys := make([]*Deploy, len(environmentState.Deploys))
+	copy(deploys, environmentState.Deploys)
+	_sortDeploys(deploys, order)
+	return deploys
+}
+
+// Get the most recent deploy, or nil if the environment has no deploys.
+func (environmentState *EnvironmentState) Latest() *Deploy {
+	var latest *Deploy
+	for _, deploy := range environmentState.Deploys {
+		if latest == nil || deploy.MatchTime().After(latest.MatchTime()) {
+			latest = deploy
+		}
+	}
+	return latest
 }
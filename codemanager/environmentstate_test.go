@@ -0,0 +1,79 @@
+package codemanager
+
+import (
+	"testing"
+	"time"
+)
+
+// Readers call SortedDeploys concurrently while holding only a read lock, so it
+// must not reorder the shared slice.
+func TestSortedDeploysDoesNotReorder(t *testing.T) {
+	environmentState := EnvironmentState{Environment: "production"}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		environmentState.Deploys = append(environmentState.Deploys, &Deploy{
+			Environment: "production",
+			Status:      Deployed,
+			QueuedAt:    start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	original := make([]*Deploy, len(environmentState.Deploys))
+	copy(original, environmentState.Deploys)
+
+	sorted := environmentState.SortedDeploys(Descending)
+	if sorted[0] != original[len(original)-1] {
+		t.Errorf("SortedDeploys(Descending)[0] is not the latest deploy")
+	}
+
+	for i, deploy := range environmentState.Deploys {
+		if deploy != original[i] {
+			t.Fatalf("SortedDeploys reordered Deploys at %d", i)
+		}
+	}
+}
+
+// SqliteStore can load environments that have no deploys.
+func TestEnvironmentWithoutDeploys(t *testing.T) {
+	newCodeState := func() *CodeState {
+		return &CodeState{Environments: map[string]*EnvironmentState{
+			"empty": &EnvironmentState{Environment: "empty"},
+			"production": &EnvironmentState{
+				Environment: "production",
+				Deploys: []*Deploy{&Deploy{
+					Environment: "production",
+					Status:      Deployed,
+					QueuedAt:    time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+				}},
+			},
+		}}
+	}
+
+	codeState := newCodeState()
+	if latest := codeState.Environments["empty"].Latest(); latest != nil {
+		t.Errorf("Latest() = %v, expected nil", latest)
+	}
+
+	environments := codeState.SortedEnvironments()
+	if len(environments) != 2 || environments[0].Environment != "empty" {
+		t.Errorf("SortedEnvironments() returned the wrong environments")
+	}
+
+	rawCodeState := RawCodeState{
+		DeploysStatus:         &RawDeploysStatus{},
+		FileSyncStorageStatus: &RawFileSyncStorageStatus{},
+	}
+	if _, err := codeState.UpdateFromRawCodeState(&rawCodeState); err != nil {
+		t.Fatalf("UpdateFromRawCodeState: %v", err)
+	}
+	if latest := codeState.Environments["empty"].Latest(); latest == nil || latest.Status != Deleted {
+		t.Errorf("Environment without deploys wasn't marked deleted")
+	}
+
+	codeState = newCodeState()
+	retention := Retention{Default: RetentionPolicy{Count: 1}}
+	results := retention.Apply(codeState, time.Now())
+	if len(results) != 1 || !results[0].Dropped || codeState.Environments["empty"] != nil {
+		t.Errorf("Retention didn't drop the environment without deploys: %+v", results)
+	}
+}
@@ -0,0 +1,74 @@
+package codemanager
+
+import (
+	"fmt"
+	"time"
+)
+
+// The request couldn't be sent, or the response couldn't be read.
+type TransportError struct {
+	Url string
+	Err error
+}
+
+func (err *TransportError) Error() string {
+	return fmt.Sprintf("Error requesting %s: %v", err.Url, err.Err)
+}
+
+func (err *TransportError) Unwrap() error {
+	return err.Err
+}
+
+// Code Manager responded with an unexpected HTTP status.
+type HttpStatusError struct {
+	Url        string
+	StatusCode int
+	Status     string
+	Body       []byte
+	RetryAfter time.Duration // From the Retry-After header, if any
+}
+
+func (err *HttpStatusError) Error() string {
+	return fmt.Sprintf("Unexpected status %q from %s: %s",
+		err.Status, err.Url, err.Body)
+}
+
+// The response (or file) wasn't valid JSON.
+type DecodeError struct {
+	Source string
+	Err    error
+}
+
+func (err *DecodeError) Error() string {
+	return fmt.Sprintf("Error decoding JSON from %s: %v", err.Source, err.Err)
+}
+
+func (err *DecodeError) Unwrap() error {
+	return err.Err
+}
+
+// The JSON was valid, but didn't have the structure we expected.
+type SchemaError struct {
+	Path    string // e.g. "deploys-status.queued[0].environment"
+	Message string
+}
+
+func (err *SchemaError) Error() string {
+	return fmt.Sprintf("Invalid status data at %s: %s", err.Path, err.Message)
+}
+
+func schemaErrorf(path string, format string, args ...interface{}) *SchemaError {
+	return &SchemaError{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+// Is the error likely to go away if the request is retried?
+func IsTemporary(err error) bool {
+	switch err := err.(type) {
+	case *TransportError:
+		return true
+	case *HttpStatusError:
+		return err.StatusCode == 429 || err.StatusCode >= 500
+	default:
+		return false
+	}
+}
@@ -0,0 +1,157 @@
+package codemanager
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Criteria for selecting deploys. Zero values match everything.
+type DeployFilter struct {
+	Environment *EnvironmentPattern
+	Statuses    []DeployStatus
+	Categories  []string
+	Sha         string // Prefix of the SHA
+	Since       time.Time
+	Until       time.Time
+}
+
+// Matches environment names with a glob, or with a regular expression if it's
+// written /like this/.
+type EnvironmentPattern struct {
+	glob   string
+	regexp *regexp.Regexp
+}
+
+func ParseEnvironmentPattern(pattern string) (*EnvironmentPattern, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		compiled, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid environment regular expression %q: %v",
+				pattern, err)
+		}
+		return &EnvironmentPattern{regexp: compiled}, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("Invalid environment pattern %q: %v", pattern, err)
+	}
+
+	return &EnvironmentPattern{glob: pattern}, nil
+}
+
+func (pattern *EnvironmentPattern) Match(name string) bool {
+	if pattern.regexp != nil {
+		return pattern.regexp.MatchString(name)
+	}
+
+	matched, _ := path.Match(pattern.glob, name)
+	return matched
+}
+
+// Split a comma-separated list, dropping empty items.
+func ParseList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Parse a comma-separated list of status names, e.g. "failed,ghost".
+func ParseDeployStatuses(list string) ([]DeployStatus, error) {
+	statuses := []DeployStatus{}
+	for _, name := range ParseList(list) {
+		status, err := ParseDeployStatus(name)
+		if err != nil {
+			return statuses, err
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func (filter *DeployFilter) Match(deploy *Deploy) bool {
+	if filter.Environment != nil && !filter.Environment.Match(deploy.Environment) {
+		return false
+	}
+
+	if filter.Sha != "" && !strings.HasPrefix(deploy.Sha, filter.Sha) {
+		return false
+	}
+
+	if len(filter.Statuses) > 0 {
+		found := false
+		for _, status := range filter.Statuses {
+			if deploy.Status == status {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if len(filter.Categories) > 0 {
+		found := false
+		for _, category := range filter.Categories {
+			if deploy.Category == category {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	matchTime := deploy.MatchTime()
+	if !filter.Since.IsZero() && matchTime.Before(filter.Since) {
+		return false
+	}
+
+	if !filter.Until.IsZero() && matchTime.After(filter.Until) {
+		return false
+	}
+
+	return true
+}
+
+// Get deploys matching filter, most recent first. This returns a new slice and
+// does not reorder environmentState.Deploys.
+func (environmentState *EnvironmentState) FilterDeploys(filter DeployFilter) []*Deploy {
+	deploys := []*Deploy{}
+	for _, deploy := range environmentState.Deploys {
+		if filter.Match(deploy) {
+			deploys = append(deploys, deploy)
+		}
+	}
+
+	_sortDeploys(deploys, Descending)
+	return deploys
+}
+
+// Get deploys matching filter, most recent first. If environment is "", deploys
+// from all environments are returned.
+func (codeState *CodeState) QueryDeploys(environment string, filter DeployFilter) []*Deploy {
+	deploys := []*Deploy{}
+	for name, environmentState := range codeState.Environments {
+		if environment == "" || environment == name {
+			deploys = append(deploys, environmentState.FilterDeploys(filter)...)
+		}
+	}
+
+	_sortDeploys(deploys, Descending)
+	return deploys
+}
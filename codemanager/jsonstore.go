@@ -0,0 +1,37 @@
+package codemanager
+
+// Stores the entire state in a JSON file, which is rewritten on every update.
+type JsonFileStore struct {
+	Path   string
+	Server string // "" for the default server
+}
+
+func (store *JsonFileStore) Load() (CodeState, error) {
+	return LoadCodeState(store.Path, store.Server)
+}
+
+func (store *JsonFileStore) Update(update func(*CodeState) error) (CodeState, error) {
+	return UpdateCodeState(store.Path, store.Server, update)
+}
+
+func (store *JsonFileStore) QueryDeploys(environment string, filter DeployFilter) ([]*Deploy, error) {
+	codeState, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return codeState.QueryDeploys(environment, filter), nil
+}
+
+func (store *JsonFileStore) ForServer(server string) Store {
+	return &JsonFileStore{Path: store.Path, Server: server}
+}
+
+func (store *JsonFileStore) Servers() ([]string, error) {
+	file, err := loadOptionalStateFile(store.Path)
+	return file.serverNames(), err
+}
+
+func (store *JsonFileStore) Close() error {
+	return nil
+}
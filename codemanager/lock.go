@@ -0,0 +1,100 @@
+package codemanager
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"time"
+)
+
+// How long to wait for another process to release the state file
+var LockTimeout = 30 * time.Second
+
+const lockRetryInterval = 100 * time.Millisecond
+
+// Another process held the lock on the state file for too long.
+type LockTimeoutError struct {
+	Path    string
+	Timeout time.Duration
+}
+
+func (err *LockTimeoutError) Error() string {
+	return fmt.Sprintf("Timed out after %v waiting for lock on %q",
+		err.Timeout, err.Path)
+}
+
+// An advisory lock on a state file. This uses a separate lock file, since the
+// state file itself is replaced on every save.
+type StateLock struct {
+	file *os.File
+}
+
+func lockPath(path string) string {
+	return path + ".lock"
+}
+
+// Lock the state file at path, waiting up to timeout for other processes to
+// release it.
+func LockCodeState(path string, timeout time.Duration) (*StateLock, error) {
+	log.Tracef("LockCodeState(%q, %v)", path, timeout)
+	file, err := os.OpenFile(lockPath(path), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for attempt := 0; ; attempt++ {
+		locked, err := tryLockFile(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		} else if locked {
+			return &StateLock{file: file}, nil
+		}
+
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, &LockTimeoutError{Path: path, Timeout: timeout}
+		}
+
+		if attempt == 0 {
+			log.Infof("Waiting for lock on %q", path)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func (lock *StateLock) Unlock() error {
+	err := unlockFile(lock.file)
+	closeErr := lock.file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Lock the state file, load a server's state from it, call update, and save
+// the result. A missing file is treated as an empty state. Nothing is saved if
+// update returns an error.
+func UpdateCodeState(path string, server string, update func(*CodeState) error) (CodeState, error) {
+	lock, err := LockCodeState(path, LockTimeout)
+	if err != nil {
+		return CodeState{}, err
+	}
+	defer lock.Unlock()
+
+	file, err := loadOptionalStateFile(path)
+	if err != nil {
+		return CodeState{}, err
+	}
+
+	codeState := file.server(server)
+	err = update(&codeState)
+	if err != nil {
+		return codeState, err
+	}
+
+	file.setServer(server, codeState)
+	return codeState, saveStateFile(&file, path)
+}
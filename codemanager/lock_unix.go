@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package codemanager
+
+import (
+	"os"
+	"syscall"
+)
+
+// Try to get an exclusive lock without blocking.
+func tryLockFile(file *os.File) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,57 @@
+package codemanager
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The golang.org/x/sys version this module uses doesn't have LockFileEx.
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// Try to get an exclusive lock without blocking. This locks the whole file.
+func tryLockFile(file *os.File) (bool, error) {
+	var overlapped syscall.Overlapped
+	result, _, err := procLockFileEx.Call(
+		file.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately,
+		0,
+		0xffffffff,
+		0xffffffff,
+		uintptr(unsafe.Pointer(&overlapped)))
+	if result != 0 {
+		return true, nil
+	}
+
+	if err == errorLockViolation || err == syscall.ERROR_IO_PENDING {
+		return false, nil
+	}
+
+	return false, err
+}
+
+func unlockFile(file *os.File) error {
+	var overlapped syscall.Overlapped
+	result, _, err := procUnlockFileEx.Call(
+		file.Fd(),
+		0,
+		0xffffffff,
+		0xffffffff,
+		uintptr(unsafe.Pointer(&overlapped)))
+	if result == 0 {
+		return err
+	}
+
+	return nil
+}
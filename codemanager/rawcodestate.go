@@ -0,0 +1,165 @@
+package codemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// The response from the /code-manager/v1/deploys/status endpoint
+type RawCodeState struct {
+	DeploysStatus         *RawDeploysStatus         `json:"deploys-status"`
+	FileSyncStorageStatus *RawFileSyncStorageStatus `json:"file-sync-storage-status"`
+	// Only present if file sync clients (compilers) are configured.
+	FileSyncClientStatus *RawFileSyncClientStatus `json:"file-sync-client-status"`
+}
+
+type RawDeploysStatus struct {
+	New       []RawDeploy `json:"new"`
+	Queued    []RawDeploy `json:"queued"`
+	Deploying []RawDeploy `json:"deploying"`
+	Failed    []RawDeploy `json:"failed"`
+}
+
+type RawFileSyncStorageStatus struct {
+	Deployed []RawDeploy `json:"deployed"`
+}
+
+type RawFileSyncClientStatus struct {
+	AllSynced bool                         `json:"all-synced"`
+	Clients   map[string]RawFileSyncClient `json:"file-sync-clients"`
+}
+
+type RawFileSyncClient struct {
+	LastCheckInTime   string      `json:"last_check_in_time"`
+	SyncedWithStorage bool        `json:"synced-with-file-sync-storage"`
+	Deployed          []RawDeploy `json:"deployed"`
+}
+
+// A deploy in any of the lists in the status response. Which fields are set
+// depends on the list.
+type RawDeploy struct {
+	Environment string       `json:"environment"`
+	QueuedAt    string       `json:"queued-at"`
+	Date        string       `json:"date"`
+	Sha         string       `json:"deploy-signature"`
+	Error       *DeployError `json:"error"`
+}
+
+// An error returned by Code Manager
+type DeployError struct {
+	Kind    string                 `json:"kind"`
+	Details map[string]interface{} `json:"details,omitempty"`
+	Msg     string                 `json:"msg"`
+}
+
+func (deployError *DeployError) Error() string {
+	return fmt.Sprintf("%s: %s", deployError.Kind, deployError.Msg)
+}
+
+// Decode and validate the JSON returned by the deploys status endpoint.
+func DecodeRawCodeState(source string, rawJson []byte) (*RawCodeState, error) {
+	rawCodeState := RawCodeState{}
+	err := json.Unmarshal(rawJson, &rawCodeState)
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		return nil, schemaErrorf(typeErr.Field, "expected %s, got %s",
+			typeErr.Type, typeErr.Value)
+	} else if err != nil {
+		return nil, &DecodeError{Source: source, Err: err}
+	}
+
+	err = rawCodeState.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &rawCodeState, nil
+}
+
+// Check that required fields are present and dates are valid.
+func (rawCodeState *RawCodeState) Validate() error {
+	if rawCodeState.DeploysStatus == nil {
+		return schemaErrorf("deploys-status", "missing")
+	}
+
+	lists := map[string][]RawDeploy{
+		"deploys-status.new":       rawCodeState.DeploysStatus.New,
+		"deploys-status.queued":    rawCodeState.DeploysStatus.Queued,
+		"deploys-status.deploying": rawCodeState.DeploysStatus.Deploying,
+		"deploys-status.failed":    rawCodeState.DeploysStatus.Failed,
+	}
+
+	if rawCodeState.FileSyncStorageStatus == nil {
+		return schemaErrorf("file-sync-storage-status", "missing")
+	}
+	lists["file-sync-storage-status.deployed"] =
+		rawCodeState.FileSyncStorageStatus.Deployed
+
+	if rawCodeState.FileSyncClientStatus != nil {
+		for name, client := range rawCodeState.FileSyncClientStatus.Clients {
+			path := "file-sync-client-status.file-sync-clients." + name
+			_, err := parseRawDate(path+".last_check_in_time", client.LastCheckInTime)
+			if err != nil {
+				return err
+			}
+			lists[path+".deployed"] = client.Deployed
+		}
+	}
+
+	for path, rawDeploys := range lists {
+		for i, rawDeploy := range rawDeploys {
+			err := rawDeploy.validate(fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (rawDeploy *RawDeploy) validate(path string) error {
+	if rawDeploy.Environment == "" {
+		return schemaErrorf(path+".environment", "missing")
+	}
+
+	_, err := parseRawDate(path+".queued-at", rawDeploy.QueuedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = parseRawDate(path+".date", rawDeploy.Date)
+	return err
+}
+
+// Parse a date from the API. An empty string is the zero time.
+func parseRawDate(path string, rawDate string) (time.Time, error) {
+	if rawDate == "" {
+		return time.Time{}, nil
+	}
+
+	date, err := time.Parse(RFC3339Micro, rawDate)
+	if err != nil {
+		return date, schemaErrorf(path, "invalid date %q", rawDate)
+	}
+
+	return date, nil
+}
+
+// Convert to a Deploy. This assumes the RawCodeState has been validated, so
+// invalid dates are treated as missing.
+func (rawDeploy *RawDeploy) Deploy(status DeployStatus) Deploy {
+	deploy := Deploy{
+		Environment: rawDeploy.Environment,
+		Status:      status,
+		Sha:         rawDeploy.Sha,
+		Error:       rawDeploy.Error,
+	}
+
+	deploy.QueuedAt, _ = parseRawDate("queued-at", rawDeploy.QueuedAt)
+	deploy.FinishedAt, _ = parseRawDate("date", rawDeploy.Date)
+
+	deploy.Classify()
+
+	return deploy
+}
@@ -0,0 +1,193 @@
+package codemanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A duration that may also be written in days, e.g. "90d".
+type Age time.Duration
+
+func ParseAge(value string) (Age, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(value, "d"), 64)
+		if err == nil {
+			return Age(days * float64(24*time.Hour)), nil
+		}
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid age %q: expected a duration like 90d or 12h", value)
+	}
+
+	return Age(duration), nil
+}
+
+func (age *Age) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+
+	*age, err = ParseAge(value)
+	return err
+}
+
+func (age Age) String() string {
+	duration := time.Duration(age)
+	if duration >= 24*time.Hour && duration%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", duration/(24*time.Hour))
+	}
+	return duration.String()
+}
+
+// Which deploys to keep for an environment. A deploy is kept if any rule
+// selects it. Unfinished deploys and the most recent deploy are always kept.
+type RetentionPolicy struct {
+	Count           int  `yaml:"count"`   // Keep this many of the most recent deploys
+	MaxAge          Age  `yaml:"max-age"` // Keep deploys newer than this
+	KeepLastSuccess bool `yaml:"keep-last-success"`
+	KeepLastFailure bool `yaml:"keep-last-failure"`
+
+	// Remove the environment entirely if it has been deleted for this long.
+	DropDeletedAfter Age `yaml:"drop-deleted-after"`
+}
+
+// A policy for environments matching a pattern. It replaces the default policy
+// entirely.
+type RetentionOverride struct {
+	Pattern         string `yaml:"pattern"`
+	RetentionPolicy `yaml:",inline"`
+
+	pattern *EnvironmentPattern
+}
+
+type Retention struct {
+	Default   RetentionPolicy     `yaml:"default"`
+	Overrides []RetentionOverride `yaml:"environments"`
+}
+
+// What a retention policy did (or would do) to one environment
+type RetentionResult struct {
+	Environment string
+	Kept        int
+	Removed     int
+	Dropped     bool // The whole environment was removed
+}
+
+// Compile patterns. This must be called before Apply.
+func (retention *Retention) Validate() error {
+	for i := range retention.Overrides {
+		override := &retention.Overrides[i]
+		pattern, err := ParseEnvironmentPattern(override.Pattern)
+		if err != nil {
+			return err
+		}
+		override.pattern = pattern
+	}
+
+	return nil
+}
+
+// Is anything configured?
+func (retention *Retention) IsEmpty() bool {
+	return retention.Default == RetentionPolicy{} && len(retention.Overrides) == 0
+}
+
+// The policy for an environment: the first matching override, or the default.
+func (retention *Retention) PolicyFor(environment string) RetentionPolicy {
+	for _, override := range retention.Overrides {
+		if override.pattern != nil && override.pattern.Match(environment) {
+			return override.RetentionPolicy
+		}
+	}
+
+	return retention.Default
+}
+
+// Remove deploys and environments that the policies don't keep. Returns a
+// result for each environment that changed, sorted by name.
+func (retention *Retention) Apply(codeState *CodeState, now time.Time) []RetentionResult {
+	results := []RetentionResult{}
+
+	for _, environmentState := range codeState.SortedEnvironments() {
+		name := environmentState.Environment
+		policy := retention.PolicyFor(name)
+		result := policy.apply(environmentState, now)
+
+		if result.Dropped {
+			delete(codeState.Environments, name)
+		}
+
+		if result.Dropped || result.Removed > 0 {
+			results = append(results, result)
+		}
+	}
+
+	return results
+}
+
+func (policy *RetentionPolicy) apply(environmentState *EnvironmentState, now time.Time) RetentionResult {
+	deploys := environmentState.SortedDeploys(Descending)
+	result := RetentionResult{Environment: environmentState.Environment}
+
+	if len(deploys) == 0 {
+		// There's nothing to keep.
+		result.Dropped = true
+		return result
+	}
+
+	latest := deploys[0]
+	if policy.DropDeletedAfter > 0 && latest.Status == Deleted &&
+		now.Sub(latest.DisplayTime()) > time.Duration(policy.DropDeletedAfter) {
+		result.Removed = len(deploys)
+		result.Dropped = true
+		return result
+	}
+
+	if policy.Count == 0 && policy.MaxAge == 0 {
+		// No limits, so keep everything.
+		result.Kept = len(deploys)
+		return result
+	}
+
+	keep := make([]bool, len(deploys))
+	keep[0] = true
+
+	foundSuccess, foundFailure := false, false
+	for i, deploy := range deploys {
+		if !deploy.Status.Finished() || i < policy.Count {
+			keep[i] = true
+		}
+
+		if policy.MaxAge > 0 && now.Sub(deploy.MatchTime()) < time.Duration(policy.MaxAge) {
+			keep[i] = true
+		}
+
+		if deploy.Status == Deployed && !foundSuccess {
+			foundSuccess = true
+			keep[i] = keep[i] || policy.KeepLastSuccess
+		}
+
+		if deploy.Status == Failed && !foundFailure {
+			foundFailure = true
+			keep[i] = keep[i] || policy.KeepLastFailure
+		}
+	}
+
+	kept := []*Deploy{}
+	for i, deploy := range deploys {
+		if keep[i] {
+			kept = append(kept, deploy)
+		}
+	}
+
+	result.Kept = len(kept)
+	result.Removed = len(deploys) - len(kept)
+	environmentState.Deploys = kept
+	return result
+}
@@ -0,0 +1,95 @@
+package codemanager
+
+import (
+	"crypto/tls"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// How to retry API requests that fail with temporary errors.
+type RetryPolicy struct {
+	Retries    int           // Retries after the first attempt
+	Backoff    time.Duration // Delay before the first retry; doubles each time
+	MaxBackoff time.Duration // Limit for the delay; 0 for no limit
+
+	// Limit for all attempts and delays together; 0 for no limit
+	MaxTime time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Retries:    4,
+	Backoff:    time.Second,
+	MaxBackoff: 30 * time.Second,
+}
+
+// Exponential backoff with jitter: a random delay between half and all of
+// Backoff * 2^attempt. If the server asked us to wait longer, wait as long as
+// it asked, up to MaxBackoff.
+func (policy RetryPolicy) delay(attempt int, err error) time.Duration {
+	delay := policy.Backoff
+	for i := 0; i < attempt && delay < math.MaxInt64/2; i++ {
+		if policy.MaxBackoff > 0 && delay >= policy.MaxBackoff {
+			break
+		}
+		delay *= 2
+	}
+	if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+
+	if delay > 0 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+
+	var statusError *HttpStatusError
+	if errors.As(err, &statusError) && statusError.RetryAfter > delay {
+		delay = statusError.RetryAfter
+		if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+			delay = policy.MaxBackoff
+		}
+	}
+
+	return delay
+}
+
+// Should a request that failed with err be retried? Requests that might have
+// changed something (e.g. started a deploy) are only retried if we know the
+// server didn't act on them.
+func isRetryable(method string, err error) bool {
+	var certificateError *tls.CertificateVerificationError
+	if errors.As(err, &certificateError) {
+		// Retrying won't fix the CA configuration.
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		// The request was never sent.
+		return true
+	}
+
+	var statusError *HttpStatusError
+	if errors.As(err, &statusError) {
+		switch statusError.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return true
+		}
+	}
+
+	return method == http.MethodGet && IsTemporary(err)
+}
+
+// Parse a Retry-After header in seconds. HTTP dates are also allowed, but
+// Puppet Server doesn't send them.
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,34 @@
+package codemanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelayDoubles(t *testing.T) {
+	for _, maxBackoff := range []time.Duration{0, time.Minute} {
+		policy := RetryPolicy{Backoff: time.Second, MaxBackoff: maxBackoff}
+		for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+			delay := policy.delay(attempt, nil)
+			if delay < want/2 || delay > want {
+				t.Errorf("MaxBackoff %v, attempt %d: delay %v, want between %v and %v",
+					maxBackoff, attempt, delay, want/2, want)
+			}
+		}
+	}
+}
+
+func TestRetryDelayMaxBackoff(t *testing.T) {
+	policy := RetryPolicy{Backoff: time.Second, MaxBackoff: 3 * time.Second}
+	for attempt := 0; attempt < 100; attempt++ {
+		if delay := policy.delay(attempt, nil); delay > policy.MaxBackoff || delay < 0 {
+			t.Errorf("attempt %d: delay %v, want at most %v", attempt, delay, policy.MaxBackoff)
+		}
+	}
+
+	// Without a limit, the delay mustn't overflow.
+	policy.MaxBackoff = 0
+	if delay := policy.delay(100, nil); delay <= 0 {
+		t.Errorf("attempt 100 without MaxBackoff: delay %v", delay)
+	}
+}
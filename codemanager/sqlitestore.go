@@ -0,0 +1,456 @@
+package codemanager
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// Stores environments and deploys as rows in a SQLite database. Updates only
+// write the rows that changed.
+//
+// Each deploy is stored as JSON in the data column. The other deploy columns
+// are copies of fields used for queries.
+type SqliteStore struct {
+	Path   string
+	Server string // "" for the default server
+	db     *sql.DB
+}
+
+// Stored in PRAGMA user_version. Version 0 didn't have the server columns.
+const sqliteSchemaVersion = 1
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS environments (
+	server TEXT NOT NULL DEFAULT '',
+	name TEXT NOT NULL,
+	PRIMARY KEY (server, name)
+);
+
+CREATE TABLE IF NOT EXISTS deploys (
+	id INTEGER PRIMARY KEY,
+	server TEXT NOT NULL DEFAULT '',
+	environment TEXT NOT NULL,
+	status TEXT NOT NULL,
+	sha TEXT NOT NULL,
+	match_time INTEGER NOT NULL,
+	data TEXT NOT NULL,
+	FOREIGN KEY (server, environment)
+		REFERENCES environments (server, name) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS deploys_environment_time
+	ON deploys (server, environment, match_time);
+CREATE INDEX IF NOT EXISTS deploys_time ON deploys (match_time);
+`
+
+// Move version 0 tables aside, create the current schema, and copy the rows
+// into it. Foreign keys must be off while this runs.
+const sqliteMigrateFromVersion0 = `
+DROP INDEX deploys_environment_time;
+DROP INDEX deploys_time;
+ALTER TABLE environments RENAME TO environments_v0;
+ALTER TABLE deploys RENAME TO deploys_v0;
+` + sqliteSchema + `
+INSERT INTO environments (server, name) SELECT '', name FROM environments_v0;
+INSERT INTO deploys (id, server, environment, status, sha, match_time, data)
+	SELECT id, '', environment, status, sha, match_time, data FROM deploys_v0;
+DROP TABLE deploys_v0;
+DROP TABLE environments_v0;
+`
+
+func OpenSqliteStore(path string) (*SqliteStore, error) {
+	log.Tracef("OpenSqliteStore(%q)", path)
+
+	// Transactions take the write lock immediately so that concurrent updates
+	// wait for each other rather than failing when they try to write.
+	dsn := fmt.Sprintf("file:%s?_txlock=immediate&_busy_timeout=%d&_foreign_keys=1",
+		path, LockTimeout/time.Millisecond)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = initSqliteSchema(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SqliteStore{Path: path, db: db}, nil
+}
+
+// Create the tables, or upgrade them from an older version.
+func initSqliteSchema(db *sql.DB) error {
+	version, err := sqliteUserVersion(db)
+	if err != nil || version == sqliteSchemaVersion {
+		return err
+	}
+
+	// PRAGMA foreign_keys only applies to one connection, and can't be changed
+	// inside a transaction.
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF")
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Check again now that we have the lock, in case another process just
+	// upgraded the database.
+	version, err = sqliteUserVersion(tx)
+	if err != nil || version == sqliteSchemaVersion {
+		return err
+	} else if version > sqliteSchemaVersion {
+		return fmt.Errorf("Database schema version %d is newer than this program supports (%d)",
+			version, sqliteSchemaVersion)
+	}
+
+	var tables int
+	err = tx.QueryRow(
+		"SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'environments'").
+		Scan(&tables)
+	if err != nil {
+		return err
+	}
+
+	schema := sqliteSchema
+	if tables > 0 {
+		log.Infof("Upgrading database schema from version %d to %d",
+			version, sqliteSchemaVersion)
+		schema = sqliteMigrateFromVersion0
+	}
+
+	_, err = tx.Exec(schema)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", sqliteSchemaVersion))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func sqliteUserVersion(queryer sqliteQueryer) (int, error) {
+	var version int
+	err := queryer.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+func (store *SqliteStore) ForServer(server string) Store {
+	return &SqliteStore{Path: store.Path, Server: server, db: store.db}
+}
+
+func (store *SqliteStore) Servers() ([]string, error) {
+	rows, err := store.db.Query("SELECT DISTINCT server FROM environments ORDER BY server")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	servers := []string{}
+	for rows.Next() {
+		var server string
+		err = rows.Scan(&server)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+
+	return servers, rows.Err()
+}
+
+func (store *SqliteStore) Close() error {
+	return store.db.Close()
+}
+
+// Times are stored as microseconds since the Unix epoch, which can represent
+// the zero time.
+func timeToSqlite(t time.Time) int64 {
+	return t.Unix()*1000000 + int64(t.Nanosecond()/1000)
+}
+
+// Anything that can run queries: *sql.DB or *sql.Tx
+type sqliteQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Load deploys, and return the JSON that was loaded for each one so that we
+// can tell which ones have changed.
+func (store *SqliteStore) load(queryer sqliteQueryer) (CodeState, map[int64][]byte, error) {
+	codeState := CodeState{Environments: map[string]*EnvironmentState{}}
+	loaded := map[int64][]byte{}
+
+	rows, err := queryer.Query("SELECT name FROM environments WHERE server = ?",
+		store.Server)
+	if err != nil {
+		return codeState, loaded, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return codeState, loaded, err
+		}
+
+		codeState.Environments[name] = &EnvironmentState{Environment: name}
+	}
+
+	if err = rows.Err(); err != nil {
+		return codeState, loaded, err
+	}
+
+	deploys, err := store.queryDeploys(queryer, "", nil)
+	if err != nil {
+		return codeState, loaded, err
+	}
+
+	for _, row := range deploys {
+		environmentState := codeState.Environments[row.deploy.Environment]
+		if environmentState == nil {
+			return codeState, loaded, fmt.Errorf(
+				"Deploy %d refers to missing environment %q",
+				row.deploy.storeId, row.deploy.Environment)
+		}
+
+		environmentState.Deploys = append(environmentState.Deploys, row.deploy)
+		loaded[row.deploy.storeId] = row.data
+	}
+
+	return codeState, loaded, nil
+}
+
+type sqliteDeployRow struct {
+	deploy *Deploy
+	data   []byte
+}
+
+func (store *SqliteStore) queryDeploys(queryer sqliteQueryer, where string, args []interface{}) ([]sqliteDeployRow, error) {
+	query := "SELECT id, data FROM deploys WHERE server = ?"
+	if where != "" {
+		query += " AND " + where
+	}
+	query += " ORDER BY match_time DESC"
+
+	rows, err := queryer.Query(query, append([]interface{}{store.Server}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deploys := []sqliteDeployRow{}
+	for rows.Next() {
+		var row sqliteDeployRow
+		var id int64
+
+		err = rows.Scan(&id, &row.data)
+		if err != nil {
+			return nil, err
+		}
+
+		row.deploy = &Deploy{}
+		err = json.Unmarshal(row.data, row.deploy)
+		if err != nil {
+			return nil, &DecodeError{Source: fmt.Sprintf("deploy %d", id), Err: err}
+		}
+
+		row.deploy.storeId = id
+		deploys = append(deploys, row)
+	}
+
+	return deploys, rows.Err()
+}
+
+func (store *SqliteStore) Load() (CodeState, error) {
+	codeState, _, err := store.load(store.db)
+	return codeState, err
+}
+
+func (store *SqliteStore) Update(update func(*CodeState) error) (CodeState, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return CodeState{}, err
+	}
+	defer tx.Rollback()
+
+	codeState, loaded, err := store.load(tx)
+	if err != nil {
+		return codeState, err
+	}
+
+	environmentsLoaded := map[string]bool{}
+	for name := range codeState.Environments {
+		environmentsLoaded[name] = true
+	}
+
+	err = update(&codeState)
+	if err != nil {
+		return codeState, err
+	}
+
+	err = store.save(tx, &codeState, environmentsLoaded, loaded)
+	if err != nil {
+		return codeState, err
+	}
+
+	return codeState, tx.Commit()
+}
+
+// Write changes to environments and deploys.
+func (store *SqliteStore) save(tx *sql.Tx, codeState *CodeState, environmentsLoaded map[string]bool, loaded map[int64][]byte) error {
+	inserted, updated, deleted := 0, 0, 0
+
+	for name, environmentState := range codeState.Environments {
+		if !environmentsLoaded[name] {
+			_, err := tx.Exec("INSERT INTO environments (server, name) VALUES (?, ?)",
+				store.Server, name)
+			if err != nil {
+				return err
+			}
+		}
+		delete(environmentsLoaded, name)
+
+		for _, deploy := range environmentState.Deploys {
+			data, err := json.Marshal(deploy)
+			if err != nil {
+				return err
+			}
+
+			// Only reuse an ID that was loaded from this server. A deploy
+			// copied from another store or server gets a new row.
+			oldData, found := loaded[deploy.storeId]
+			if deploy.storeId != 0 && found {
+				delete(loaded, deploy.storeId)
+				if bytes.Equal(data, oldData) {
+					continue
+				}
+
+				_, err = tx.Exec(`
+					UPDATE deploys SET environment = ?, status = ?, sha = ?,
+						match_time = ?, data = ?
+					WHERE id = ? AND server = ?`,
+					name, deploy.Status.String(), deploy.Sha,
+					timeToSqlite(deploy.MatchTime()), data,
+					deploy.storeId, store.Server)
+				if err != nil {
+					return err
+				}
+				updated++
+				continue
+			}
+
+			result, err := tx.Exec(`
+				INSERT INTO deploys (server, environment, status, sha, match_time, data)
+					VALUES (?, ?, ?, ?, ?, ?)`,
+				store.Server, name, deploy.Status.String(), deploy.Sha,
+				timeToSqlite(deploy.MatchTime()), data)
+			if err != nil {
+				return err
+			}
+
+			deploy.storeId, err = result.LastInsertId()
+			if err != nil {
+				return err
+			}
+			inserted++
+		}
+	}
+
+	// Anything left in loaded was removed from the state.
+	for id := range loaded {
+		_, err := tx.Exec("DELETE FROM deploys WHERE id = ? AND server = ?",
+			id, store.Server)
+		if err != nil {
+			return err
+		}
+		deleted++
+	}
+
+	for name := range environmentsLoaded {
+		_, err := tx.Exec("DELETE FROM environments WHERE server = ? AND name = ?",
+			store.Server, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Debugf("SqliteStore: inserted %d, updated %d, deleted %d deploys",
+		inserted, updated, deleted)
+	return nil
+}
+
+func (store *SqliteStore) QueryDeploys(environment string, filter DeployFilter) ([]*Deploy, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if environment != "" {
+		conditions = append(conditions, "environment = ?")
+		args = append(args, environment)
+	}
+
+	if len(filter.Statuses) > 0 {
+		placeholders := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			placeholders[i] = "?"
+			args = append(args, status.String())
+		}
+		conditions = append(conditions,
+			"status IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	if filter.Sha != "" {
+		conditions = append(conditions, "substr(sha, 1, ?) = ?")
+		args = append(args, len(filter.Sha), filter.Sha)
+	}
+
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "match_time >= ?")
+		args = append(args, timeToSqlite(filter.Since))
+	}
+
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "match_time <= ?")
+		args = append(args, timeToSqlite(filter.Until))
+	}
+
+	rows, err := store.queryDeploys(store.db, strings.Join(conditions, " AND "), args)
+	if err != nil {
+		return nil, err
+	}
+
+	deploys := make([]*Deploy, 0, len(rows))
+	for _, row := range rows {
+		// The query should have done all the filtering, but DeployFilter may
+		// have criteria that aren't stored in columns.
+		if filter.Match(row.deploy) {
+			deploys = append(deploys, row.deploy)
+		}
+	}
+
+	return deploys, nil
+}
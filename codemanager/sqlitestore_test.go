@@ -0,0 +1,102 @@
+package codemanager
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func openTestSqliteStore(t *testing.T, path string) *SqliteStore {
+	t.Helper()
+
+	store, err := OpenSqliteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func replaceState(t *testing.T, store Store, codeState CodeState) {
+	t.Helper()
+
+	_, err := store.Update(func(destination *CodeState) error {
+		*destination = codeState
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loadState(t *testing.T, store Store) CodeState {
+	t.Helper()
+
+	codeState, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return codeState
+}
+
+// Fail unless the two states have the same environments and deploys.
+func assertSameState(t *testing.T, name string, got CodeState, want CodeState) {
+	t.Helper()
+
+	for environment, environmentState := range got.Environments {
+		if len(environmentState.Deploys) == 0 {
+			t.Errorf("%s: environment %s has no deploys", name, environment)
+		}
+	}
+
+	// SortedDeploys gives both sides the same order.
+	normalize := func(codeState CodeState) string {
+		environments := map[string][]*Deploy{}
+		for environment, environmentState := range codeState.Environments {
+			environments[environment] = environmentState.SortedDeploys(Descending)
+		}
+		encoded, err := json.Marshal(environments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(encoded)
+	}
+
+	if normalize(got) != normalize(want) {
+		t.Errorf("%s: state changed", name)
+	}
+}
+
+// Deploys keep the row IDs of the store they were loaded from. Saving them to
+// another store or server must not overwrite that store's rows with the same
+// IDs.
+func TestSqliteCopyBetweenStores(t *testing.T) {
+	dir := t.TempDir()
+	small := loadCorpus(t, "small/*.json")
+	large := loadCorpus(t, "large/0[1-3]*.json")
+
+	source := openTestSqliteStore(t, filepath.Join(dir, "a.db"))
+	replaceState(t, source, small)
+
+	destination := openTestSqliteStore(t, filepath.Join(dir, "b.db"))
+	replaceState(t, destination.ForServer("other"), large)
+
+	replaceState(t, destination, loadState(t, source))
+
+	assertSameState(t, "b.db other", loadState(t, destination.ForServer("other")), large)
+	assertSameState(t, "b.db default", loadState(t, destination), small)
+	assertSameState(t, "a.db default", loadState(t, source), small)
+}
+
+func TestSqliteCopyBetweenServers(t *testing.T) {
+	small := loadCorpus(t, "small/*.json")
+
+	store := openTestSqliteStore(t, filepath.Join(t.TempDir(), "state.db"))
+	replaceState(t, store, small)
+
+	replaceState(t, store.ForServer("prod"), loadState(t, store))
+
+	assertSameState(t, "default", loadState(t, store), small)
+	assertSameState(t, "prod", loadState(t, store.ForServer("prod")), small)
+}
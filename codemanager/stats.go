@@ -0,0 +1,190 @@
+package codemanager
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Summary of a set of durations
+type DurationStats struct {
+	Count int
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// Deploy statistics for one environment, or for all of them.
+type DeployStats struct {
+	Environment string // "" for all environments
+
+	Deploys   int // Finished deploys, not counting deletions
+	Succeeded int
+	Failed    int
+	Ghosts    int
+
+	// Number of deploys in each failure category
+	Categories map[string]int
+
+	QueueWait  DurationStats // Queued until started deploying
+	DeployTime DurationStats // Started deploying until finished
+	TotalTime  DurationStats // Queued until finished
+
+	queueWaits  []time.Duration
+	deployTimes []time.Duration
+	totalTimes  []time.Duration
+}
+
+// Percentage of finished deploys that succeeded
+func (stats DeployStats) SuccessRate() float64 {
+	if stats.Deploys == 0 {
+		return 0
+	}
+	return 100 * float64(stats.Succeeded) / float64(stats.Deploys)
+}
+
+// Percentage of finished deploys that failed
+func (stats DeployStats) FailureRate() float64 {
+	if stats.Deploys == 0 {
+		return 0
+	}
+	return 100 * float64(stats.Failed) / float64(stats.Deploys)
+}
+
+// Failure categories sorted by count, most common first
+func (stats DeployStats) SortedCategories() []CategoryCount {
+	counts := make([]CategoryCount, 0, len(stats.Categories))
+	for category, count := range stats.Categories {
+		counts = append(counts, CategoryCount{category, count})
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Category < counts[j].Category
+	})
+
+	return counts
+}
+
+type CategoryCount struct {
+	Category string
+	Count    int
+}
+
+func (stats *DeployStats) add(deploy *Deploy) {
+	if deploy.Category != "" {
+		if stats.Categories == nil {
+			stats.Categories = map[string]int{}
+		}
+		stats.Categories[deploy.Category]++
+	}
+
+	switch deploy.Status {
+	case Deployed:
+		stats.Deploys++
+		stats.Succeeded++
+	case Failed:
+		stats.Deploys++
+		stats.Failed++
+	case Ghost:
+		stats.Deploys++
+		stats.Ghosts++
+	default:
+		// Unfinished or deleted
+		return
+	}
+
+	// Times are approximate, so ignore negative durations.
+	addDuration := func(durations *[]time.Duration, start time.Time, end time.Time) {
+		if !start.IsZero() && !end.IsZero() && !end.Before(start) {
+			*durations = append(*durations, end.Sub(start))
+		}
+	}
+
+	if deploy.HasQueuedTime() && !deploy.Requested {
+		addDuration(&stats.queueWaits, deploy.QueuedAt, deploy.StartedAt)
+		addDuration(&stats.totalTimes, deploy.QueuedAt, deploy.FinishedAt)
+	}
+	addDuration(&stats.deployTimes, deploy.StartedAt, deploy.FinishedAt)
+}
+
+func (stats *DeployStats) finish() {
+	stats.QueueWait = summarizeDurations(stats.queueWaits)
+	stats.DeployTime = summarizeDurations(stats.deployTimes)
+	stats.TotalTime = summarizeDurations(stats.totalTimes)
+}
+
+// Round a duration for display.
+func FormatDuration(duration time.Duration) string {
+	if duration < time.Minute {
+		return duration.Round(100 * time.Millisecond).String()
+	}
+	return duration.Round(time.Second).String()
+}
+
+// Use the nearest-rank method to find a percentile of sorted durations.
+func percentile(sorted []time.Duration, percent int) time.Duration {
+	rank := (percent*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func summarizeDurations(durations []time.Duration) DurationStats {
+	if len(durations) == 0 {
+		return DurationStats{}
+	}
+
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, duration := range sorted {
+		total += duration
+	}
+
+	return DurationStats{
+		Count: len(sorted),
+		Mean:  total / time.Duration(len(sorted)),
+		P50:   percentile(sorted, 50),
+		P90:   percentile(sorted, 90),
+		P99:   percentile(sorted, 99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// Compute statistics for each environment and overall. Environments are sorted
+// by name.
+func ComputeStats(deploys []*Deploy) (overall DeployStats, environments []DeployStats) {
+	byEnvironment := map[string]*DeployStats{}
+	for _, deploy := range deploys {
+		stats := byEnvironment[deploy.Environment]
+		if stats == nil {
+			stats = &DeployStats{Environment: deploy.Environment}
+			byEnvironment[deploy.Environment] = stats
+		}
+
+		stats.add(deploy)
+		overall.add(deploy)
+	}
+
+	overall.finish()
+
+	environments = make([]DeployStats, 0, len(byEnvironment))
+	for _, stats := range byEnvironment {
+		stats.finish()
+		environments = append(environments, *stats)
+	}
+
+	sort.Slice(environments, func(i, j int) bool {
+		return strings.ToLower(environments[i].Environment) <
+			strings.ToLower(environments[j].Environment)
+	})
+
+	return overall, environments
+}
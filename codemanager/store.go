@@ -0,0 +1,62 @@
+package codemanager
+
+import (
+	"strings"
+)
+
+// Persistent storage for CodeState.
+type Store interface {
+	// Load the entire state.
+	Load() (CodeState, error)
+
+	// Lock the store, load the state, call update, and save the result. Nothing
+	// is saved if update returns an error.
+	Update(update func(*CodeState) error) (CodeState, error)
+
+	// Get deploys matching filter, most recent first. If environment is "",
+	// deploys from all environments are returned.
+	QueryDeploys(environment string, filter DeployFilter) ([]*Deploy, error)
+
+	// The same storage, but containing the state of a different Code Manager
+	// server. The default server is "". Closing either store closes both.
+	ForServer(server string) Store
+
+	// Names of the servers that have state stored, sorted.
+	Servers() ([]string, error)
+
+	Close() error
+}
+
+const sqlitePrefix = "sqlite:"
+
+// Open the store at path. Paths starting with "sqlite:" or ending with ".db",
+// ".sqlite", or ".sqlite3" are SQLite databases; anything else is a JSON file.
+// The file is created if it doesn't exist.
+func OpenStore(path string) (Store, error) {
+	file, sqlite := parseStorePath(path)
+	if sqlite {
+		return OpenSqliteStore(file)
+	}
+
+	return &JsonFileStore{Path: file}, nil
+}
+
+// The file a store path refers to, e.g. "state.db" for "sqlite:state.db".
+func StoreFile(path string) string {
+	file, _ := parseStorePath(path)
+	return file
+}
+
+func parseStorePath(path string) (file string, sqlite bool) {
+	if strings.HasPrefix(path, sqlitePrefix) {
+		return strings.TrimPrefix(path, sqlitePrefix), true
+	}
+
+	for _, extension := range []string{".db", ".sqlite", ".sqlite3"} {
+		if strings.HasSuffix(path, extension) {
+			return path, true
+		}
+	}
+
+	return path, false
+}
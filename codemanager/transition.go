@@ -0,0 +1,86 @@
+package codemanager
+
+import (
+	"time"
+)
+
+// A status change seen while merging a status update into the state.
+type Transition struct {
+	Environment string
+	Sha         string
+	From        DeployStatus
+	To          DeployStatus
+	ObservedAt  time.Time
+	Error       *DeployError `json:",omitempty"`
+
+	// The deploy wasn't in the state before, so From is meaningless.
+	NewDeploy bool `json:",omitempty"`
+
+	// The environment wasn't in the state before. Only one transition, for the
+	// most recent deploy, is reported for a new environment.
+	NewEnvironment bool `json:",omitempty"`
+
+	deploy *Deploy
+}
+
+// A status we saw a deploy in, and when we first saw it.
+type StatusChange struct {
+	Status     DeployStatus
+	ObservedAt time.Time
+}
+
+// Short name for the kind of transition: "new" for a new environment,
+// otherwise the name of the new status.
+func (transition Transition) Event() string {
+	if transition.NewEnvironment {
+		return "new"
+	}
+	return transition.To.String()
+}
+
+func newTransition(from DeployStatus, deploy *Deploy) Transition {
+	return Transition{
+		Environment: deploy.Environment,
+		Sha:         deploy.Sha,
+		From:        from,
+		To:          deploy.Status,
+		Error:       deploy.Error,
+		deploy:      deploy,
+	}
+}
+
+// Add the transition to its deploy's history.
+func (transition *Transition) record() {
+	deploy := transition.deploy
+	if deploy == nil {
+		return
+	}
+
+	count := len(deploy.History)
+	if count > 0 && deploy.History[count-1].Status == transition.To {
+		return
+	}
+
+	deploy.History = append(deploy.History, StatusChange{
+		Status:     transition.To,
+		ObservedAt: transition.ObservedAt,
+	})
+}
+
+// A status from a deploy's history, and how long the deploy stayed in it.
+type StatusPeriod struct {
+	StatusChange
+	Duration time.Duration // 0 for the latest status
+}
+
+func (deploy *Deploy) StatusPeriods() []StatusPeriod {
+	periods := make([]StatusPeriod, len(deploy.History))
+	for i, change := range deploy.History {
+		periods[i].StatusChange = change
+		if i+1 < len(deploy.History) {
+			periods[i].Duration = deploy.History[i+1].ObservedAt.Sub(change.ObservedAt)
+		}
+	}
+
+	return periods
+}
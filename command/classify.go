@@ -0,0 +1,44 @@
+package command
+
+import (
+	"fmt"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	classifyCommand.PersistentFlags().StringP("state-file", "f", "",
+		"File or database to store state in.")
+	classifyCommand.MarkPersistentFlagRequired("state-file")
+	RootCommand.AddCommand(classifyCommand)
+}
+
+var classifyCommand = &cobra.Command{
+	Use:   "classify",
+	Short: "Categorize recorded failures again using the current rules",
+	Args:  cobra.NoArgs,
+	Run: func(command *cobra.Command, args []string) {
+		stateFile := getFlagString(command, "state-file")
+
+		// Don't create the state file if it doesn't exist.
+		requireStateFile(stateFile)
+
+		counts := map[string]int{}
+		updateStateFile(stateFile, func(codeState *codemanager.CodeState) error {
+			for _, environmentState := range codeState.Environments {
+				for _, deploy := range environmentState.Deploys {
+					deploy.Reclassify()
+					if deploy.Category != "" {
+						counts[deploy.Category]++
+					}
+				}
+			}
+			return nil
+		})
+
+		stats := codemanager.DeployStats{Categories: counts}
+		for _, count := range stats.SortedCategories() {
+			fmt.Printf("%-45s  %5d\n", count.Category, count.Count)
+		}
+	},
+}
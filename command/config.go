@@ -0,0 +1,376 @@
+package command
+
+import (
+	"fmt"
+	"github.com/danielparks/code-manager-dashboard/auth"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	"github.com/danielparks/code-manager-dashboard/notify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultConfigPath = "~/.config/code-manager-dashboard.yaml"
+const defaultTokenPath = "~/.puppetlabs/token"
+
+type Config struct {
+	Server    codemanager.ClientConfig `yaml:"server"`
+	Notifiers []notify.Config          `yaml:"notifiers"`
+
+	// Named servers, used instead of Server to monitor several Code Manager
+	// servers.
+	Servers []codemanager.ClientConfig `yaml:"servers"`
+
+	// Rules for categorizing failed deploys. Replaces the default rules.
+	FailureCategories []codemanager.ClassifierRule `yaml:"failure-categories"`
+
+	// Used by trim and serve
+	Retention codemanager.Retention `yaml:"retention"`
+
+	// Who may use the dashboard served by serve
+	Auth auth.Config `yaml:"auth"`
+}
+
+func init() {
+	RootCommand.PersistentFlags().String("config", defaultConfigPath,
+		"Configuration file")
+	RootCommand.PersistentFlags().String("server", "",
+		"Name of the server (from servers in the config file) to use")
+}
+
+// The server selected by configureServer, and the error to report if a server
+// is needed but none was selected.
+var selectedServer string
+var selectedServerErr error
+
+// Expand a leading ~ in a path to the user's home directory.
+func expandPath(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return filepath.Join(home, path[1:])
+}
+
+// Load the configuration file. It's fine for the default file to be missing,
+// but if the user specified a file it must exist.
+func loadConfig(command *cobra.Command) Config {
+	config := Config{
+		Server: codemanager.DefaultClientConfig(),
+	}
+
+	path := expandPath(getFlagString(command, "config"))
+	configYaml, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !command.Flags().Changed("config") {
+		log.Debugf("Config file %q does not exist", path)
+		return config
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	err = yaml.UnmarshalStrict(configYaml, &config)
+	if err != nil {
+		log.Fatalf("Error parsing %q: %v", path, err)
+	}
+
+	err = config.validateServers()
+	if err != nil {
+		log.Fatalf("Error in %q: %v", path, err)
+	}
+
+	return config
+}
+
+func (config *Config) validateServers() error {
+	if len(config.Servers) == 0 {
+		return nil
+	}
+
+	if config.Server.Host != "" {
+		return fmt.Errorf("use either server or servers, not both")
+	}
+
+	names := map[string]bool{}
+	for _, server := range config.Servers {
+		if server.Name == "" {
+			return fmt.Errorf("every server in servers needs a name")
+		}
+		if names[server.Name] {
+			return fmt.Errorf("duplicate server name %q", server.Name)
+		}
+		names[server.Name] = true
+	}
+
+	return nil
+}
+
+// Settings for a server in the configuration file. The default server is "".
+func (config *Config) findServer(name string) (codemanager.ClientConfig, bool) {
+	if name == "" && len(config.Servers) == 0 {
+		return config.Server, true
+	}
+
+	for _, server := range config.Servers {
+		if server.Name == name {
+			return server, true
+		}
+	}
+
+	return codemanager.ClientConfig{}, false
+}
+
+// Choose the server to use from --server. If it isn't set and the config file
+// lists exactly one server, that one is used.
+func configureServer(command *cobra.Command) {
+	selectedServer = getFlagString(command, "server")
+	selectedServerErr = nil
+
+	config := loadConfig(command)
+	if selectedServer == "" {
+		switch len(config.Servers) {
+		case 0:
+		case 1:
+			selectedServer = config.Servers[0].Name
+		default:
+			selectedServerErr = fmt.Errorf(
+				"Several servers are configured; choose one with --server")
+		}
+	} else if _, found := config.findServer(selectedServer); !found {
+		selectedServerErr = fmt.Errorf("No server named %q in the config file",
+			selectedServer)
+	}
+}
+
+// Get the server selected with --server, or exit if there isn't one.
+func getSelectedServer() string {
+	if selectedServerErr != nil {
+		log.Fatal(selectedServerErr)
+	}
+
+	return selectedServer
+}
+
+// Flags used by commands that talk to the Code Manager API. If the command
+// already has its own --timeout flag, that will be used for API requests too.
+func addClientFlags(command *cobra.Command) {
+	flags := command.PersistentFlags()
+	flags.String("host", "",
+		"Code Manager host (env CODE_MANAGER_HOST)")
+	flags.Uint16("port", codemanager.DefaultPort,
+		"Code Manager port (env CODE_MANAGER_PORT)")
+	flags.String("ca-file", "",
+		"CA bundle to verify Code Manager with (env CODE_MANAGER_CA_FILE)")
+	flags.String("token-file", "",
+		"File containing an RBAC token (env CODE_MANAGER_TOKEN_FILE)")
+	if flags.Lookup("timeout") == nil {
+		flags.Duration("timeout", codemanager.DefaultTimeout,
+			"Timeout for API requests (env CODE_MANAGER_TIMEOUT)")
+	}
+	flags.Duration("connect-timeout", codemanager.DefaultConnectTimeout,
+		"Timeout for connecting to Code Manager (env CODE_MANAGER_CONNECT_TIMEOUT)")
+	flags.Duration("read-timeout", 0,
+		"Timeout for Code Manager to start responding; 0 for none (env CODE_MANAGER_READ_TIMEOUT)")
+	flags.Int("retries", codemanager.DefaultRetryPolicy.Retries,
+		"Times to retry API requests that fail temporarily (env CODE_MANAGER_RETRIES)")
+}
+
+// Get a client for the Code Manager API, or exit if it's not configured.
+func getApiClient(command *cobra.Command) *codemanager.ApiClient {
+	apiClient, err := codemanager.TypicalApiClient(getClientConfig(command))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return apiClient
+}
+
+// Get Code Manager connection settings, and exit if no host is configured.
+func getClientConfig(command *cobra.Command) codemanager.ClientConfig {
+	config := resolveClientConfig(command)
+	if config.Host == "" {
+		log.Fatal("No Code Manager host configured. Use --host, " +
+			"CODE_MANAGER_HOST, or the server.host config setting.")
+	}
+
+	return config
+}
+
+// Get Code Manager connection settings for the selected server. Flags override
+// environment variables, which override the configuration file.
+func resolveClientConfig(command *cobra.Command) codemanager.ClientConfig {
+	fileConfig := loadConfig(command)
+	config, _ := fileConfig.findServer(getSelectedServer())
+	flags := command.Flags()
+
+	if value := os.Getenv("CODE_MANAGER_HOST"); value != "" {
+		config.Host = value
+	}
+
+	if value := os.Getenv("CODE_MANAGER_PORT"); value != "" {
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			log.Fatalf("Invalid CODE_MANAGER_PORT %q: %v", value, err)
+		}
+		config.Port = uint16(port)
+	}
+
+	if value := os.Getenv("CODE_MANAGER_CA_FILE"); value != "" {
+		config.CaPath = value
+	}
+
+	if value := os.Getenv("CODE_MANAGER_TOKEN_FILE"); value != "" {
+		config.TokenPath = value
+	}
+
+	getEnvDuration("CODE_MANAGER_TIMEOUT", &config.Timeout)
+	getEnvDuration("CODE_MANAGER_CONNECT_TIMEOUT", &config.ConnectTimeout)
+	getEnvDuration("CODE_MANAGER_READ_TIMEOUT", &config.ReadTimeout)
+
+	if value := os.Getenv("CODE_MANAGER_RETRIES"); value != "" {
+		retries, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			log.Fatalf("Invalid CODE_MANAGER_RETRIES %q: %v", value, err)
+		}
+		config.Retries = int(retries)
+	}
+
+	if flags.Changed("host") {
+		config.Host = getFlagString(command, "host")
+	}
+
+	if flags.Changed("port") {
+		config.Port = getFlagUint16(command, "port")
+	}
+
+	if flags.Changed("ca-file") {
+		config.CaPath = getFlagString(command, "ca-file")
+	}
+
+	if flags.Changed("token-file") {
+		config.TokenPath = getFlagString(command, "token-file")
+	}
+
+	if flags.Changed("timeout") {
+		config.Timeout = getFlagDuration(command, "timeout")
+	}
+
+	if flags.Changed("connect-timeout") {
+		config.ConnectTimeout = getFlagDuration(command, "connect-timeout")
+	}
+
+	if flags.Changed("read-timeout") {
+		config.ReadTimeout = getFlagDuration(command, "read-timeout")
+	}
+
+	if flags.Changed("retries") {
+		config.Retries = getFlagInt(command, "retries")
+	}
+
+	finishClientConfig(&config)
+	return config
+}
+
+// Expand paths and load the token.
+func finishClientConfig(config *codemanager.ClientConfig) {
+	config.CaPath = expandPath(config.CaPath)
+	loadClientToken(config)
+}
+
+// Set a duration from an environment variable, if it's set.
+func getEnvDuration(name string, duration *time.Duration) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", name, value, err)
+	}
+	*duration = parsed
+}
+
+func loadClientToken(config *codemanager.ClientConfig) {
+	if config.TokenPath != "" {
+		config.TokenPath = expandPath(config.TokenPath)
+		err := config.LoadToken()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Older versions only supported the pe_token environment variable.
+	if value := os.Getenv("pe_token"); value != "" {
+		config.RbacToken = value
+		return
+	}
+
+	// Fall back to the token saved by `puppet access login`.
+	config.TokenPath = expandPath(defaultTokenPath)
+	err := config.LoadToken()
+	if os.IsNotExist(err) {
+		log.Debugf("No token found in %q", config.TokenPath)
+		config.TokenPath = ""
+	} else if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Get the notifiers from the configuration file, or exit if they're invalid.
+func getNotifiers(command *cobra.Command) notify.Notifiers {
+	notifiers, err := notify.NewNotifiers(loadConfig(command).Notifiers)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return notifiers
+}
+
+// Use the failure classification rules from the configuration file, if any.
+func configureClassifier(command *cobra.Command) {
+	rules := loadConfig(command).FailureCategories
+	if len(rules) == 0 {
+		return
+	}
+
+	classifier, err := codemanager.NewClassifier(rules)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	codemanager.SetClassifier(classifier)
+}
+
+// Get the web authentication settings from the configuration file, or exit if
+// they're invalid.
+func getAuthenticators(command *cobra.Command) *auth.Authenticators {
+	config := loadConfig(command).Auth
+	for i := range config.Tokens {
+		config.Tokens[i].TokenFile = expandPath(config.Tokens[i].TokenFile)
+	}
+	if config.Htpasswd != nil {
+		config.Htpasswd.File = expandPath(config.Htpasswd.File)
+	}
+	if config.Rbac != nil {
+		config.Rbac.CaFile = expandPath(config.Rbac.CaFile)
+	}
+
+	authenticators, err := auth.New(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return authenticators
+}
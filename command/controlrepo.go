@@ -0,0 +1,85 @@
+package command
+
+import (
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Flags for looking up deployed commits in a clone of the control repo.
+func addControlRepoFlags(command *cobra.Command) {
+	flags := command.PersistentFlags()
+	flags.String("control-repo", "",
+		"Local clone of the control repo to look up commits in")
+	flags.String("control-repo-base", "production",
+		"Environment to compare other environments' commits to")
+}
+
+// Commit details to show with deploys. A nil *CommitLookup shows nothing.
+type CommitLookup struct {
+	Repo *codemanager.ControlRepo
+
+	// Environments are compared to the deployed commit of this environment.
+	Base    string
+	BaseSha string
+}
+
+// Returns nil if --control-repo wasn't passed.
+func getControlRepo(command *cobra.Command) *codemanager.ControlRepo {
+	path := getFlagString(command, "control-repo")
+	if path == "" {
+		return nil
+	}
+
+	repo, err := codemanager.NewControlRepo(expandPath(path))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return repo
+}
+
+// Returns nil if --control-repo wasn't passed.
+func getCommitLookup(command *cobra.Command, codeState *codemanager.CodeState) *CommitLookup {
+	repo := getControlRepo(command)
+	if repo == nil {
+		return nil
+	}
+
+	base := getFlagString(command, "control-repo-base")
+	return &CommitLookup{
+		Repo:    repo,
+		Base:    base,
+		BaseSha: codeState.DeployedSha(base),
+	}
+}
+
+// Fetch the control repo if any of shas or the base commit are missing.
+func (lookup *CommitLookup) Refresh(shas []string) {
+	if lookup == nil {
+		return
+	}
+	lookup.Repo.Refresh(append(shas, lookup.BaseSha))
+}
+
+func (lookup *CommitLookup) Commit(sha string) *codemanager.CommitInfo {
+	if lookup == nil {
+		return nil
+	}
+	return lookup.Repo.Commit(sha)
+}
+
+// Compare an environment's deployed commit to the base environment's. Returns
+// nil for the base environment itself.
+func (lookup *CommitLookup) Compare(environmentState *codemanager.EnvironmentState) *codemanager.Comparison {
+	if lookup == nil || environmentState.Environment == lookup.Base {
+		return nil
+	}
+
+	deploy := environmentState.LatestDeployed()
+	if deploy == nil {
+		return nil
+	}
+
+	return lookup.Repo.Compare(deploy.Sha, lookup.BaseSha)
+}
@@ -0,0 +1,60 @@
+package command
+
+import (
+	"fmt"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+func init() {
+	deployCommand.PersistentFlags().StringP("state-file", "f", "",
+		"File to record deploys in.")
+	deployCommand.PersistentFlags().BoolP("all", "a", false,
+		"Deploy all environments.")
+	deployCommand.PersistentFlags().BoolP("wait", "w", false,
+		"Wait for deploys to finish (see --timeout).")
+	addClientFlags(deployCommand)
+	RootCommand.AddCommand(deployCommand)
+}
+
+var deployCommand = &cobra.Command{
+	Use:   "deploy [ENVIRONMENT ...]",
+	Short: "Deploy environments with Code Manager",
+	Args:  cobra.ArbitraryArgs,
+	Run: func(command *cobra.Command, args []string) {
+		stateFile := getFlagString(command, "state-file")
+		all := getFlagBool(command, "all")
+		wait := getFlagBool(command, "wait")
+
+		if all == (len(args) > 0) {
+			log.Fatal("Specify either --all or at least one environment")
+		}
+
+		apiClient := getApiClient(command)
+
+		requestedAt := time.Now()
+		results, err := apiClient.Deploy(args, all, wait)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		deploys := make([]codemanager.Deploy, len(results))
+		for i, result := range results {
+			deploys[i] = result.Deploy(requestedAt)
+			fmt.Printf("%-45s  %-9s  %s\n", result.Environment, deploys[i].Status,
+				result.Sha)
+			if result.Error != nil {
+				fmt.Printf("    %s\n", result.Error.Msg)
+			}
+		}
+
+		if stateFile != "" {
+			updateStateFile(stateFile, func(codeState *codemanager.CodeState) error {
+				codeState.AddRequestedDeploys(deploys)
+				return nil
+			})
+		}
+	},
+}
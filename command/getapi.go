@@ -4,12 +4,12 @@ import (
 	"github.com/danielparks/code-manager-dashboard/codemanager"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"os"
 )
 
 func init() {
-	getapiCommand.PersistentFlags().StringP("state-file", "f", "", "File to store state in.")
+	getapiCommand.PersistentFlags().StringP("state-file", "f", "", "File or database to store state in.")
 	getapiCommand.PersistentFlags().BoolP("show", "S", false, "Show state.")
+	addClientFlags(getapiCommand)
 	RootCommand.AddCommand(getapiCommand)
 }
 
@@ -19,33 +19,24 @@ var getapiCommand = &cobra.Command{
 	Run: func(command *cobra.Command, args []string) {
 		stateFile := getFlagString(command, "state-file")
 		show := getFlagBool(command, "show")
+		apiClient := getApiClient(command)
+		notifiers := getNotifiers(command)
 
-		server := "puppet.ops.puppetlabs.net"
-		caPath := "/Users/daniel/work/puppetca.ops.puppetlabs.net.pem"
-
-		var codeState codemanager.CodeState
-		var err error
-
-		if stateFile != "" {
-			codeState, err = loadOptionalCodeState(stateFile)
-			if err != nil {
-				log.Fatal(err)
-			}
+		rawCodeState, err := apiClient.GetRawCodeState()
+		if err != nil {
+			log.Fatal(err)
 		}
 
-		apiClient := codemanager.TypicalApiClient(server, os.Getenv("pe_token"), caPath)
-		rawCodeState := apiClient.GetRawCodeState()
-		codeState.UpdateFromRawCodeState(rawCodeState)
+		var transitions []codemanager.Transition
+		codeState := updateStateFile(stateFile, func(codeState *codemanager.CodeState) error {
+			transitions, err = codeState.UpdateFromRawCodeState(rawCodeState)
+			return err
+		})
 
-		if show {
-			ShowEnvironments(&codeState)
-		}
+		notifiers.Notify(getSelectedServer(), transitions)
 
-		if stateFile != "" {
-			err = codemanager.SaveCodeState(&codeState, stateFile)
-			if err != nil {
-				log.Fatal(err)
-			}
+		if show {
+			ShowEnvironments(&codeState, nil)
 		}
 	},
 }
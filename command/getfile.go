@@ -1,7 +1,7 @@
 package command
 
 import (
-	"encoding/json"
+	"fmt"
 	"github.com/danielparks/code-manager-dashboard/codemanager"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -9,7 +9,7 @@ import (
 )
 
 func init() {
-	getfileCommand.PersistentFlags().StringP("state-file", "f", "", "File to store state in.")
+	getfileCommand.PersistentFlags().StringP("state-file", "f", "", "File or database to store state in.")
 	getfileCommand.PersistentFlags().BoolP("show", "S", false, "Show state.")
 	RootCommand.AddCommand(getfileCommand)
 }
@@ -20,43 +20,41 @@ var getfileCommand = &cobra.Command{
 	Run: func(command *cobra.Command, args []string) {
 		stateFile := getFlagString(command, "state-file")
 		show := getFlagBool(command, "show")
+		notifiers := getNotifiers(command)
 
-		var codeState codemanager.CodeState
-		var err error
+		rawCodeStates := make([]*codemanager.RawCodeState, len(args))
+		for i, source := range args {
+			rawCodeStates[i] = loadRawCodeState(source)
+		}
 
-		if stateFile != "" {
-			codeState, err = loadOptionalCodeState(stateFile)
-			if err != nil {
-				log.Fatal(err)
+		transitions := []codemanager.Transition{}
+		codeState := updateStateFile(stateFile, func(codeState *codemanager.CodeState) error {
+			for i, rawCodeState := range rawCodeStates {
+				newTransitions, err := codeState.UpdateFromRawCodeState(rawCodeState)
+				if err != nil {
+					return fmt.Errorf("%s: %v", args[i], err)
+				}
+				transitions = append(transitions, newTransitions...)
 			}
-		}
+			return nil
+		})
 
-		for _, source := range args {
-			codeState.UpdateFromRawCodeState(loadRawCodeState(source))
-		}
+		notifiers.Notify(getSelectedServer(), transitions)
 
 		if show {
-			ShowEnvironments(&codeState)
-		}
-
-		if stateFile != "" {
-			err = codemanager.SaveCodeState(&codeState, stateFile)
-			if err != nil {
-				log.Fatal(err)
-			}
+			ShowEnvironments(&codeState, nil)
 		}
 	},
 }
 
 // Get deploy status from file
-func loadRawCodeState(source string) map[string]interface{} {
+func loadRawCodeState(source string) *codemanager.RawCodeState {
 	codeStateJson, err := ioutil.ReadFile(source)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	rawCodeState := map[string]interface{}{}
-	err = json.Unmarshal(codeStateJson, &rawCodeState)
+	rawCodeState, err := codemanager.DecodeRawCodeState(source, codeStateJson)
 	if err != nil {
 		log.Fatal(err)
 	}
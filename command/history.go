@@ -0,0 +1,190 @@
+package command
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+func init() {
+	flags := historyCommand.PersistentFlags()
+	flags.StringP("state-file", "f", "", "File or database to store state in.")
+	historyCommand.MarkPersistentFlagRequired("state-file")
+	flags.StringP("env", "e", "",
+		"Only show environments matching a glob, or a /regular expression/.")
+	flags.StringP("status", "s", "",
+		"Only show deploys with these statuses (comma-separated).")
+	flags.String("category", "",
+		"Only show failures in these categories (comma-separated).")
+	flags.String("since", "",
+		"Only show deploys since a time (RFC 3339, YYYY-MM-DD, or a duration ago).")
+	flags.String("until", "",
+		"Only show deploys until a time (RFC 3339, YYYY-MM-DD, or a duration ago).")
+	flags.String("sha", "", "Only show deploys of SHAs starting with this.")
+	flags.IntP("limit", "n", 0, "Maximum number of deploys to show (0 for all).")
+	flags.StringP("output", "o", "table", "Output format: table, json, csv, or ndjson.")
+	RootCommand.AddCommand(historyCommand)
+}
+
+var historyCommand = &cobra.Command{
+	Use:   "history",
+	Short: "Show recorded deploys, most recent first",
+	Args:  cobra.NoArgs,
+	Run: func(command *cobra.Command, args []string) {
+		filter := getDeployFilter(command)
+		limit := getFlagInt(command, "limit")
+
+		output := getFlagString(command, "output")
+		writer, ok := historyWriters[output]
+		if !ok {
+			log.Fatalf("Invalid output format %q", output)
+		}
+
+		store := openExistingStore(getFlagString(command, "state-file"))
+		defer store.Close()
+
+		deploys, err := store.QueryDeploys("", filter)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if limit > 0 && len(deploys) > limit {
+			deploys = deploys[:limit]
+		}
+
+		err = writer(deploys)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func getDeployFilter(command *cobra.Command) codemanager.DeployFilter {
+	filter := codemanager.DeployFilter{
+		Categories: codemanager.ParseList(getFlagString(command, "category")),
+		Sha:        getFlagString(command, "sha"),
+		Since:      getFlagTime(command, "since"),
+		Until:      getFlagTime(command, "until"),
+	}
+
+	var err error
+	if pattern := getFlagString(command, "env"); pattern != "" {
+		filter.Environment, err = codemanager.ParseEnvironmentPattern(pattern)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	filter.Statuses, err = codemanager.ParseDeployStatuses(getFlagString(command, "status"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return filter
+}
+
+// Parse a time flag. Accepts RFC 3339 times, local dates (YYYY-MM-DD), and
+// durations, which are subtracted from the current time.
+func getFlagTime(command *cobra.Command, name string) time.Time {
+	value := getFlagString(command, name)
+	if value == "" {
+		return time.Time{}
+	}
+
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed
+	}
+
+	if parsed, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return parsed
+	}
+
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration)
+	}
+
+	log.Fatalf("Invalid --%s %q: expected an RFC 3339 time, YYYY-MM-DD, or a duration",
+		name, value)
+	return time.Time{}
+}
+
+var historyWriters = map[string]func([]*codemanager.Deploy) error{
+	"table":  writeHistoryTable,
+	"json":   writeHistoryJson,
+	"csv":    writeHistoryCsv,
+	"ndjson": writeHistoryNdjson,
+}
+
+func writeHistoryTable(deploys []*codemanager.Deploy) error {
+	location := getLocation()
+	fmt.Printf("%-45s  %-9s  %-29s  %-8s  %s\n",
+		"ENVIRONMENT", "STATUS", "TIME", "SHA", "CATEGORY")
+	for _, deploy := range deploys {
+		localDate := deploy.MatchTime().Truncate(time.Second).In(location)
+		fmt.Printf("%-45s  %-9s  %-29s  %-8s  %s\n", deploy.Environment,
+			deploy.Status, localDate, codemanager.ShortSha(deploy.Sha), deploy.Category)
+	}
+
+	return nil
+}
+
+func writeHistoryJson(deploys []*codemanager.Deploy) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(deploys)
+}
+
+func writeHistoryNdjson(deploys []*codemanager.Deploy) error {
+	encoder := json.NewEncoder(os.Stdout)
+	for _, deploy := range deploys {
+		if err := encoder.Encode(deploy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Format a time for CSV, leaving unknown times empty.
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(codemanager.RFC3339Micro)
+}
+
+func writeHistoryCsv(deploys []*codemanager.Deploy) error {
+	writer := csv.NewWriter(os.Stdout)
+	writer.Write([]string{"environment", "status", "sha", "queued_at",
+		"started_at", "finished_at", "estimated_at", "requested", "category",
+		"error_kind", "error_msg"})
+
+	for _, deploy := range deploys {
+		errorKind, errorMsg := "", ""
+		if deploy.Error != nil {
+			errorKind, errorMsg = deploy.Error.Kind, deploy.Error.Msg
+		}
+
+		writer.Write([]string{
+			deploy.Environment,
+			deploy.Status.String(),
+			deploy.Sha,
+			csvTime(deploy.QueuedAt),
+			csvTime(deploy.StartedAt),
+			csvTime(deploy.FinishedAt),
+			csvTime(deploy.EstimatedTime),
+			fmt.Sprint(deploy.Requested),
+			deploy.Category,
+			errorKind,
+			errorMsg,
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,148 @@
+package command
+
+import (
+	"fmt"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	migrateCommand.PersistentFlags().String("from-server", "",
+		"Only copy the state of this server (\"\" for the default server).")
+	migrateCommand.PersistentFlags().String("to-server", "",
+		"Store the state copied with --from-server under this server.")
+	migrateCommand.PersistentFlags().Bool("move", false,
+		"Remove the copied state from the source.")
+	RootCommand.AddCommand(migrateCommand)
+}
+
+var migrateCommand = &cobra.Command{
+	Use:   "migrate SOURCE DESTINATION",
+	Short: "Copy state from one state file to another",
+	Long: `Copy the state of every server from one state file to another, replacing
+the destination's state for those servers.
+
+State files whose names start with "sqlite:" or end with .db, .sqlite, or
+.sqlite3 are SQLite databases. Anything else is a JSON file. For example:
+
+  code-manager-dashboard migrate state.json state.db
+
+Use --from-server and --to-server to copy a single server's state under a
+different name. SOURCE and DESTINATION may be the same file; with --move, this
+renames the server. For example, to keep the history recorded before switching
+from "server" to "servers" in the config file:
+
+  code-manager-dashboard migrate state.db state.db --from-server "" --to-server prod --move`,
+	Args: cobra.ExactArgs(2),
+	Run: func(command *cobra.Command, args []string) {
+		source, err := codemanager.OpenStore(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer source.Close()
+
+		destination, err := codemanager.OpenStore(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer destination.Close()
+
+		servers, err := source.Servers()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// source server => destination server
+		renames := map[string]string{}
+		if command.Flags().Changed("from-server") || command.Flags().Changed("to-server") {
+			from := getFlagString(command, "from-server")
+			to := from
+			if command.Flags().Changed("to-server") {
+				to = getFlagString(command, "to-server")
+			}
+
+			if !contains(servers, from) {
+				log.Fatalf("No state for server %q in %s", from, args[0])
+			}
+
+			servers = []string{from}
+			renames[from] = to
+		} else {
+			for _, server := range servers {
+				renames[server] = server
+			}
+		}
+
+		move := getFlagBool(command, "move")
+		sameFile := args[0] == args[1]
+
+		for _, server := range servers {
+			if sameFile && renames[server] == server {
+				log.Fatalf("Cannot copy server %q onto itself", server)
+			}
+		}
+
+		environments, deploys, err := copyServers(source, destination, servers, renames, move)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("Copied %d environments with %d deploys\n", environments, deploys)
+	},
+}
+
+// Copy the state of servers from source to destination, renaming them
+// according to renames. If move is set, the state is removed from source.
+// Returns the number of environments and deploys copied.
+func copyServers(source codemanager.Store, destination codemanager.Store, servers []string, renames map[string]string, move bool) (int, int, error) {
+	environments, deploys := 0, 0
+	for _, server := range servers {
+		loaded, err := source.ForServer(server).Load()
+		if err != nil {
+			return environments, deploys, err
+		}
+
+		// Deploys loaded from a store belong to it; copy them so that the
+		// destination stores them as new deploys.
+		codeState, err := loaded.Clone()
+		if err != nil {
+			return environments, deploys, err
+		}
+
+		for _, environmentState := range codeState.Environments {
+			deploys += len(environmentState.Deploys)
+		}
+		environments += len(codeState.Environments)
+
+		_, err = destination.ForServer(renames[server]).Update(func(destinationState *codemanager.CodeState) error {
+			*destinationState = codeState
+			return nil
+		})
+		if err != nil {
+			return environments, deploys, err
+		}
+
+		if move {
+			_, err = source.ForServer(server).Update(func(sourceState *codemanager.CodeState) error {
+				sourceState.Environments = map[string]*codemanager.EnvironmentState{}
+				return nil
+			})
+			if err != nil {
+				return environments, deploys, err
+			}
+		}
+	}
+
+	return environments, deploys, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,118 @@
+package command
+
+import (
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func loadCorpusState(t *testing.T, pattern string) codemanager.CodeState {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join("..", "corpus", pattern))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no files match corpus/%s: %v", pattern, err)
+	}
+
+	var codeState codemanager.CodeState
+	for _, path := range paths {
+		rawJson, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rawCodeState, err := codemanager.DecodeRawCodeState(path, rawJson)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := codeState.UpdateFromRawCodeState(rawCodeState); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return codeState
+}
+
+func countDeploys(t *testing.T, store codemanager.Store) (int, int) {
+	t.Helper()
+
+	codeState, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deploys := 0
+	for name, environmentState := range codeState.Environments {
+		if len(environmentState.Deploys) == 0 {
+			t.Errorf("environment %s has no deploys", name)
+		}
+		deploys += len(environmentState.Deploys)
+	}
+
+	return len(codeState.Environments), deploys
+}
+
+// Copying a server within one file must leave the source server intact.
+func TestCopyServersSameFile(t *testing.T) {
+	for _, name := range []string{"state.db", "state.json"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			store, err := codemanager.OpenStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			codeState := loadCorpusState(t, "large/0[1-3]*.json")
+			_, err = store.Update(func(destination *codemanager.CodeState) error {
+				*destination = codeState
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantEnvironments, wantDeploys := countDeploys(t, store)
+
+			// The same file opened twice, as migrate does.
+			destination, err := codemanager.OpenStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer destination.Close()
+
+			environments, deploys, err := copyServers(store, destination,
+				[]string{""}, map[string]string{"": "prod"}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if environments != wantEnvironments || deploys != wantDeploys {
+				t.Errorf("copied %d environments and %d deploys, want %d and %d",
+					environments, deploys, wantEnvironments, wantDeploys)
+			}
+
+			for _, server := range []string{"", "prod"} {
+				environments, deploys := countDeploys(t, store.ForServer(server))
+				if environments != wantEnvironments || deploys != wantDeploys {
+					t.Errorf("server %q has %d environments and %d deploys, want %d and %d",
+						server, environments, deploys, wantEnvironments, wantDeploys)
+				}
+			}
+
+			_, _, err = copyServers(store, destination,
+				[]string{"prod"}, map[string]string{"prod": "dr"}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			servers, err := store.Servers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(servers) != 2 || servers[0] != "" || servers[1] != "dr" {
+				t.Errorf("after moving prod to dr, servers are %q", servers)
+			}
+		})
+	}
+}
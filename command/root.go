@@ -5,6 +5,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"os"
+	"time"
 )
 
 func init() {
@@ -32,6 +33,22 @@ func getFlagInt(command *cobra.Command, name string) int {
 	return value
 }
 
+func getFlagUint16(command *cobra.Command, name string) uint16 {
+	value, err := command.Flags().GetUint16(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return value
+}
+
+func getFlagDuration(command *cobra.Command, name string) time.Duration {
+	value, err := command.Flags().GetDuration(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return value
+}
+
 func getFlagString(command *cobra.Command, name string) string {
 	value, err := command.Flags().GetString(name)
 	if err != nil {
@@ -40,13 +57,62 @@ func getFlagString(command *cobra.Command, name string) string {
 	return value
 }
 
-func loadOptionalCodeState(path string) (codemanager.CodeState, error) {
-	state, err := codemanager.LoadCodeState(path)
-	if os.IsNotExist(err) {
-		return state, nil
+// Open the state file, scoped to the server selected with --server.
+func openStore(path string) codemanager.Store {
+	store, err := codemanager.OpenStore(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return store.ForServer(getSelectedServer())
+}
+
+// Exit if the state file doesn't exist. Opening a SQLite store creates it, so
+// check before opening one that shouldn't be created.
+func requireStateFile(path string) {
+	_, err := os.Stat(codemanager.StoreFile(path))
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Open a state file that must already exist, for commands that only read it.
+func openExistingStore(path string) codemanager.Store {
+	requireStateFile(path)
+	return openStore(path)
+}
+
+func loadStateFile(path string) codemanager.CodeState {
+	store := openExistingStore(path)
+	defer store.Close()
+
+	codeState, err := store.Load()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	return state, err
+	return codeState
+}
+
+// Update the state file, or an empty state if path is "". The state file is
+// locked while update runs.
+func updateStateFile(path string, update func(*codemanager.CodeState) error) codemanager.CodeState {
+	var codeState codemanager.CodeState
+	var err error
+
+	if path == "" {
+		err = update(&codeState)
+	} else {
+		store := openStore(path)
+		defer store.Close()
+		codeState, err = store.Update(update)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return codeState
 }
 
 var RootCommand = &cobra.Command{
@@ -63,5 +129,8 @@ var RootCommand = &cobra.Command{
 		} else {
 			log.SetLevel(log.WarnLevel)
 		}
+
+		configureClassifier(command)
+		configureServer(command)
 	},
 }
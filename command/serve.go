@@ -1,16 +1,33 @@
 package command
 
 import (
+	"github.com/danielparks/code-manager-dashboard/codemanager"
 	"github.com/danielparks/code-manager-dashboard/web"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"time"
 )
 
 func init() {
 	serveCommand.PersistentFlags().StringP("state-file", "f", "",
-		"File to store state in.")
+		"File or database to store state in.")
 	serveCommand.MarkPersistentFlagRequired("state-file")
 	serveCommand.PersistentFlags().StringP("listen-on", "l", "localhost:8080",
 		"[ADDRESS]:PORT to listen on.")
+	serveCommand.PersistentFlags().Duration("poll-interval", 30*time.Second,
+		"How often to poll the Code Manager API (0 to disable).")
+	serveCommand.PersistentFlags().Duration("trim-interval", 24*time.Hour,
+		"How often to apply the retention policy from the config file (0 to disable).")
+	serveCommand.PersistentFlags().String("dev-assets", "",
+		"Load templates and static files from this directory (e.g. web) instead of the built-in copies.")
+	serveCommand.PersistentFlags().String("tls-cert", "",
+		"Certificate to serve HTTPS with (requires --tls-key).")
+	serveCommand.PersistentFlags().String("tls-key", "",
+		"Private key for --tls-cert.")
+	serveCommand.PersistentFlags().String("tls-client-ca", "",
+		"Require client certificates signed by a CA in this bundle (e.g. the Puppet CA).")
+	addClientFlags(serveCommand)
+	addControlRepoFlags(serveCommand)
 	RootCommand.AddCommand(serveCommand)
 }
 
@@ -19,9 +36,111 @@ var serveCommand = &cobra.Command{
 	Short: "Start HTTP server",
 	Args:  cobra.NoArgs,
 	Run: func(command *cobra.Command, args []string) {
-		web.Serve(
-			getFlagString(command, "listen-on"),
-			getFlagString(command, "state-file"),
-		)
+		store, err := codemanager.OpenStore(getFlagString(command, "state-file"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer store.Close()
+
+		options := web.Options{
+			ListenOn:  getFlagString(command, "listen-on"),
+			DevAssets: getFlagString(command, "dev-assets"),
+
+			TlsCert:     expandPath(getFlagString(command, "tls-cert")),
+			TlsKey:      expandPath(getFlagString(command, "tls-key")),
+			TlsClientCa: expandPath(getFlagString(command, "tls-client-ca")),
+
+			PollInterval: getFlagDuration(command, "poll-interval"),
+			Notifiers:    getNotifiers(command),
+
+			ControlRepo:     getControlRepo(command),
+			ControlRepoBase: getFlagString(command, "control-repo-base"),
+
+			TrimInterval: getFlagDuration(command, "trim-interval"),
+
+			Auth: getAuthenticators(command),
+		}
+
+		if (options.TlsCert == "") != (options.TlsKey == "") {
+			log.Fatal("--tls-cert and --tls-key must be used together")
+		}
+
+		if options.TlsClientCa != "" && options.TlsCert == "" {
+			log.Fatal("--tls-client-ca requires --tls-cert and --tls-key")
+		}
+
+		config := loadConfig(command)
+		retention := config.Retention
+		if !retention.IsEmpty() {
+			err := retention.Validate()
+			if err != nil {
+				log.Fatal(err)
+			}
+			options.Retention = &retention
+		}
+
+		if len(config.Servers) > 0 {
+			// State recorded before switching from server to servers.
+			servers, err := store.Servers()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(servers) > 0 && servers[0] == "" {
+				log.Warn("The state file has state for the default server, which isn't configured; use `migrate --from-server \"\" --to-server NAME --move` to keep it")
+			}
+		}
+
+		if len(config.Servers) <= 1 || command.Flags().Changed("server") {
+			// Serve one server. Connection flags and environment variables apply.
+			name := getSelectedServer()
+			serverOptions := web.ServerOptions{
+				Name:  name,
+				Store: store.ForServer(name),
+			}
+			if options.PollInterval > 0 {
+				serverOptions.ApiClient = getPollApiClient(resolveClientConfig(command))
+			}
+			options.Servers = []web.ServerOptions{serverOptions}
+		} else {
+			for _, flag := range []string{"host", "port", "ca-file", "token-file"} {
+				if command.Flags().Changed(flag) {
+					log.Warnf("--%s is ignored when serving several servers; use --server to serve one", flag)
+				}
+			}
+
+			for _, clientConfig := range config.Servers {
+				serverOptions := web.ServerOptions{
+					Name:  clientConfig.Name,
+					Store: store.ForServer(clientConfig.Name),
+				}
+				if options.PollInterval > 0 {
+					finishClientConfig(&clientConfig)
+					serverOptions.ApiClient = getPollApiClient(clientConfig)
+				}
+				options.Servers = append(options.Servers, serverOptions)
+			}
+		}
+
+		web.Serve(options)
 	},
 }
+
+// Get a client to poll a server with. Returns nil if the server has no host
+// configured.
+func getPollApiClient(clientConfig codemanager.ClientConfig) *codemanager.ApiClient {
+	if clientConfig.Host == "" {
+		if clientConfig.Name == "" {
+			log.Warn("No Code Manager host configured; not polling")
+		} else {
+			log.Warnf("No host configured for server %q; not polling it", clientConfig.Name)
+		}
+		return nil
+	}
+
+	apiClient, err := codemanager.TypicalApiClient(clientConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return apiClient
+}
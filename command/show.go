@@ -5,12 +5,14 @@ import (
 	"github.com/danielparks/code-manager-dashboard/codemanager"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"os"
 	"time"
 )
 
 func init() {
-	showCommand.PersistentFlags().StringP("state-file", "f", "", "File to store state in.")
+	showCommand.PersistentFlags().StringP("state-file", "f", "", "File or database to store state in.")
 	showCommand.MarkPersistentFlagRequired("state-file")
+	addControlRepoFlags(showCommand)
 	RootCommand.AddCommand(showCommand)
 }
 
@@ -21,17 +23,30 @@ var showCommand = &cobra.Command{
 	Run: func(command *cobra.Command, args []string) {
 		stateFile := getFlagString(command, "state-file")
 
-		codeState, err := codemanager.LoadCodeState(stateFile)
-		if err != nil {
-			log.Fatal(err)
-		}
+		codeState := loadStateFile(stateFile)
+		lookup := getCommitLookup(command, &codeState)
 
 		if len(args) == 0 {
-			ShowEnvironments(&codeState)
-		} else {
-			for _, name := range args {
-				ShowEnvironmentState(codeState.Environments[name])
+			lookup.Refresh(codeState.Shas())
+			ShowEnvironments(&codeState, lookup)
+			return
+		}
+
+		missing := false
+		for _, name := range args {
+			environmentState := codeState.Environments[name]
+			if environmentState == nil {
+				log.Errorf("No such environment %q", name)
+				missing = true
+				continue
 			}
+
+			lookup.Refresh(environmentState.Shas())
+			ShowEnvironmentState(environmentState, lookup)
+		}
+
+		if missing {
+			os.Exit(1)
 		}
 	},
 }
@@ -43,7 +58,7 @@ func getLocation() *time.Location {
 }
 
 // FIXME: use ShowEnvironmentState? rename?
-func ShowEnvironments(codeState *codemanager.CodeState) {
+func ShowEnvironments(codeState *codemanager.CodeState, lookup *CommitLookup) {
 	environments := codeState.SortedEnvironments()
 	location := getLocation()
 
@@ -55,12 +70,16 @@ func ShowEnvironments(codeState *codemanager.CodeState) {
 			localDate := deploy.MatchTime().Truncate(time.Second).In(location)
 			fmt.Printf("%-45s  %-9s  %s\n", environment, deploy.Status, localDate)
 			environment = ""
+
+			showCommit(deploy, lookup, location)
 		}
+
+		showComparison(environmentState, lookup)
+		showLaggingCompilers(environmentState, location)
 	}
 }
 
-// FIXME: how do we handle non-existent environments?
-func ShowEnvironmentState(environmentState *codemanager.EnvironmentState) {
+func ShowEnvironmentState(environmentState *codemanager.EnvironmentState, lookup *CommitLookup) {
 	environment := environmentState.Environment
 	location := getLocation()
 
@@ -69,5 +88,57 @@ func ShowEnvironmentState(environmentState *codemanager.EnvironmentState) {
 		localDate := deploy.MatchTime().Truncate(time.Second).In(location)
 		fmt.Printf("%-45s  %-9s  %s\n", environment, deploy.Status, localDate)
 		environment = ""
+
+		showCommit(deploy, lookup, location)
+		showStatusHistory(deploy, location)
+	}
+
+	showComparison(environmentState, lookup)
+	showLaggingCompilers(environmentState, location)
+}
+
+func showCommit(deploy *codemanager.Deploy, lookup *CommitLookup, location *time.Location) {
+	commit := lookup.Commit(deploy.Sha)
+	if commit == nil {
+		return
+	}
+
+	date := commit.Date.Truncate(time.Second).In(location)
+	fmt.Printf("%-45s    %.8s %s (%s, %s)\n", "", commit.Sha, commit.Subject,
+		commit.Author, date)
+}
+
+func showComparison(environmentState *codemanager.EnvironmentState, lookup *CommitLookup) {
+	comparison := lookup.Compare(environmentState)
+	if comparison == nil {
+		return
+	}
+
+	fmt.Printf("%-45s  %s compared to %s\n", "", comparison, lookup.Base)
+}
+
+// Show each status the deploy was seen in, and how long it stayed there.
+func showStatusHistory(deploy *codemanager.Deploy, location *time.Location) {
+	for _, period := range deploy.StatusPeriods() {
+		observedAt := period.ObservedAt.Truncate(time.Second).In(location)
+		duration := ""
+		if period.Duration > 0 {
+			duration = "for " + codemanager.FormatDuration(period.Duration)
+		}
+
+		fmt.Printf("%-45s    %-9s  %s  %s\n", "", period.Status, observedAt, duration)
+	}
+}
+
+func showLaggingCompilers(environmentState *codemanager.EnvironmentState, location *time.Location) {
+	for _, compiler := range environmentState.LaggingCompilers() {
+		sha := compiler.Sha
+		if sha == "" {
+			sha = "nothing"
+		}
+
+		checkedInAt := compiler.CheckedInAt.Truncate(time.Second).In(location)
+		fmt.Printf("%-45s  lagging compiler %s has %s (checked in %s)\n",
+			"", compiler.Name, sha, checkedInAt)
 	}
 }
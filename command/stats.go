@@ -0,0 +1,81 @@
+package command
+
+import (
+	"fmt"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+func init() {
+	statsCommand.PersistentFlags().StringP("state-file", "f", "",
+		"File or database to store state in.")
+	statsCommand.MarkPersistentFlagRequired("state-file")
+	statsCommand.PersistentFlags().Duration("window", 7*24*time.Hour,
+		"Only include deploys from this far back.")
+	RootCommand.AddCommand(statsCommand)
+}
+
+var statsCommand = &cobra.Command{
+	Use:   "stats",
+	Short: "Show deploy duration and failure statistics",
+	Long: `Show deploy duration and failure statistics for each environment
+
+Durations are shown as p50/p90/p99:
+
+  QUEUE   time between being queued and starting to deploy
+  DEPLOY  time between starting to deploy and finishing
+  TOTAL   time between being queued and finishing
+
+Start times are approximate; they're recorded when a deploy is first seen in
+the deploying state.`,
+	Args: cobra.NoArgs,
+	Run: func(command *cobra.Command, args []string) {
+		store := openExistingStore(getFlagString(command, "state-file"))
+		defer store.Close()
+
+		window := getFlagDuration(command, "window")
+		filter := codemanager.DeployFilter{Since: time.Now().Add(-window)}
+		deploys, err := store.QueryDeploys("", filter)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		overall, environments := codemanager.ComputeStats(deploys)
+
+		fmt.Printf("%-45s  %5s  %6s  %-20s  %-20s  %s\n", "ENVIRONMENT",
+			"COUNT", "FAIL%", "QUEUE", "DEPLOY", "TOTAL")
+		for _, stats := range environments {
+			showStats(stats.Environment, &stats)
+		}
+		showStats("(all)", &overall)
+
+		if len(overall.Categories) > 0 {
+			fmt.Printf("\n%-45s  %5s\n", "FAILURE CATEGORY", "COUNT")
+			for _, count := range overall.SortedCategories() {
+				fmt.Printf("%-45s  %5d\n", count.Category, count.Count)
+			}
+		}
+	},
+}
+
+// Format as p50/p90/p99
+func formatDurationStats(stats codemanager.DurationStats) string {
+	if stats.Count == 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%s/%s/%s", codemanager.FormatDuration(stats.P50),
+		codemanager.FormatDuration(stats.P90), codemanager.FormatDuration(stats.P99))
+}
+
+func showStats(name string, stats *codemanager.DeployStats) {
+	fmt.Printf("%-45s  %5d  %5.1f%%  %-20s  %-20s  %s\n",
+		name,
+		stats.Deploys,
+		stats.FailureRate(),
+		formatDurationStats(stats.QueueWait),
+		formatDurationStats(stats.DeployTime),
+		formatDurationStats(stats.TotalTime))
+}
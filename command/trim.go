@@ -1,55 +1,130 @@
 package command
 
 import (
+	"fmt"
 	"github.com/danielparks/code-manager-dashboard/codemanager"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-
-	"fmt"
+	"time"
 )
 
 func init() {
-	trimCommand.PersistentFlags().StringP("state-file", "f", "", "File to store state in.")
+	flags := trimCommand.PersistentFlags()
+	flags.StringP("state-file", "f", "", "File or database to store state in.")
 	trimCommand.MarkPersistentFlagRequired("state-file")
-	trimCommand.PersistentFlags().IntP("count", "c", 5, "Number of deploys to keep for each environment.")
-	trimCommand.MarkPersistentFlagRequired("count")
-	trimCommand.PersistentFlags().BoolP("show", "S", false, "Show state.")
+	flags.IntP("count", "c", 0, "Number of deploys to keep for each environment.")
+	flags.String("max-age", "", "Keep deploys newer than this (e.g. 90d).")
+	flags.Bool("keep-last-success", false, "Always keep the last successful deploy.")
+	flags.Bool("keep-last-failure", false, "Always keep the last failed deploy.")
+	flags.String("drop-deleted-after", "",
+		"Remove environments that have been deleted for this long (e.g. 30d).")
+	flags.BoolP("dry-run", "n", false, "Report what would be removed without removing it.")
+	flags.BoolP("show", "S", false, "Show state.")
 	RootCommand.AddCommand(trimCommand)
 }
 
 var trimCommand = &cobra.Command{
 	Use:   "trim",
-	Short: "Trim deploys down to a specified size (per environment)",
-	Args:  cobra.NoArgs,
+	Short: "Remove old deploys according to a retention policy",
+	Long: `Remove old deploys according to a retention policy
+
+The policy comes from the retention section of the config file. Flags override
+the default policy from the config file, but not per-environment overrides.
+
+A deploy is kept if it's one of the --count most recent, if it's newer than
+--max-age, or if it's the last success or failure and --keep-last-success or
+--keep-last-failure is set. The most recent deploy and unfinished deploys are
+always kept.`,
+	Args: cobra.NoArgs,
 	Run: func(command *cobra.Command, args []string) {
 		stateFile := getFlagString(command, "state-file")
-		count := getFlagInt(command, "count")
 		show := getFlagBool(command, "show")
-
-		codeState, err := codemanager.LoadCodeState(stateFile)
-		if err != nil {
-			log.Fatal(err)
+		retention := getRetention(command)
+		if retention.IsEmpty() {
+			log.Fatal("No retention policy configured. Use --count, --max-age, " +
+				"or the retention config setting.")
 		}
 
-		TrimEnvironments(&codeState, count)
+		// Don't create the state file if it doesn't exist.
+		codeState := loadStateFile(stateFile)
 
-		if show {
-			ShowEnvironments(&codeState)
+		if getFlagBool(command, "dry-run") {
+			results := retention.Apply(&codeState, time.Now())
+			showRetentionResults(results, "Would remove")
+			return
 		}
 
-		err = codemanager.SaveCodeState(&codeState, stateFile)
-		if err != nil {
-			log.Fatal(err)
+		codeState = updateStateFile(stateFile, func(codeState *codemanager.CodeState) error {
+			results := retention.Apply(codeState, time.Now())
+			showRetentionResults(results, "Removed")
+			return nil
+		})
+
+		if show {
+			ShowEnvironments(&codeState, nil)
 		}
 	},
 }
 
-func TrimEnvironments(codeState *codemanager.CodeState, count int) {
-	for _, environmentState := range codeState.Environments {
-		environmentState.SortDeploys(codemanager.Descending)
-		if len(environmentState.Deploys) > count {
-			environmentState.Deploys = environmentState.Deploys[0:count]
-			fmt.Printf("env %s: %d\n", environmentState.Environment, len(environmentState.Deploys))
+// Get the retention policy from the config file and flags.
+func getRetention(command *cobra.Command) codemanager.Retention {
+	retention := loadConfig(command).Retention
+	policy := &retention.Default
+	flags := command.Flags()
+
+	if flags.Changed("count") {
+		policy.Count = getFlagInt(command, "count")
+	}
+
+	if flags.Changed("max-age") {
+		policy.MaxAge = getFlagAge(command, "max-age")
+	}
+
+	if flags.Changed("keep-last-success") {
+		policy.KeepLastSuccess = getFlagBool(command, "keep-last-success")
+	}
+
+	if flags.Changed("keep-last-failure") {
+		policy.KeepLastFailure = getFlagBool(command, "keep-last-failure")
+	}
+
+	if flags.Changed("drop-deleted-after") {
+		policy.DropDeletedAfter = getFlagAge(command, "drop-deleted-after")
+	}
+
+	err := retention.Validate()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return retention
+}
+
+func getFlagAge(command *cobra.Command, name string) codemanager.Age {
+	age, err := codemanager.ParseAge(getFlagString(command, name))
+	if err != nil {
+		log.Fatalf("Invalid --%s: %v", name, err)
+	}
+	return age
+}
+
+func showRetentionResults(results []codemanager.RetentionResult, verb string) {
+	if len(results) == 0 {
+		fmt.Println("Nothing to remove")
+		return
+	}
+
+	removed := 0
+	for _, result := range results {
+		removed += result.Removed
+		if result.Dropped {
+			fmt.Printf("%s environment %s (%d deploys)\n",
+				verb, result.Environment, result.Removed)
+		} else {
+			fmt.Printf("%s %d deploys from %s, keeping %d\n",
+				verb, result.Removed, result.Environment, result.Kept)
 		}
 	}
+
+	fmt.Printf("%s %d deploys from %d environments\n", verb, removed, len(results))
 }
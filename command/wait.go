@@ -0,0 +1,182 @@
+package command
+
+import (
+	"fmt"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+// Exit codes for the wait command. 1 is used for other errors.
+const (
+	WaitDeployed = 0
+	WaitFailed   = 2
+	WaitDeleted  = 3
+	WaitTimedOut = 4
+)
+
+func init() {
+	waitCommand.PersistentFlags().StringP("env", "e", "",
+		"Environment to wait for.")
+	waitCommand.MarkPersistentFlagRequired("env")
+	waitCommand.PersistentFlags().String("sha", "",
+		"Wait for this deploy signature to be deployed.")
+	waitCommand.PersistentFlags().Duration("timeout", 0,
+		"Maximum time to wait, which also limits each API request (0 to wait forever).")
+	waitCommand.PersistentFlags().Duration("interval", 5*time.Second,
+		"How often to poll the Code Manager API.")
+	// This will skip --timeout, since it's already defined above.
+	addClientFlags(waitCommand)
+	RootCommand.AddCommand(waitCommand)
+}
+
+var waitCommand = &cobra.Command{
+	Use:   "wait",
+	Short: "Wait for an environment to finish deploying",
+	Long: fmt.Sprintf(`Wait for an environment to finish deploying
+
+Exits with:
+  %d  the environment was deployed (at --sha, if specified)
+  %d  the deploy failed
+  %d  the environment was deleted
+  %d  timed out`, WaitDeployed, WaitFailed, WaitDeleted, WaitTimedOut),
+	Args: cobra.NoArgs,
+	Run: func(command *cobra.Command, args []string) {
+		environment := getFlagString(command, "env")
+		sha := getFlagString(command, "sha")
+		timeout := getFlagDuration(command, "timeout")
+		interval := getFlagDuration(command, "interval")
+
+		apiClient := getApiClient(command)
+
+		var deadline time.Time
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+
+		waiter := environmentWaiter{Environment: environment, Sha: sha}
+		for {
+			rawCodeState, err := apiClient.GetRawCodeState()
+			if err == nil {
+				err = waiter.Update(rawCodeState)
+			}
+
+			if codemanager.IsTemporary(err) {
+				log.Warn(err)
+			} else if err != nil {
+				log.Fatal(err)
+			} else if waiter.Done {
+				break
+			}
+
+			if !deadline.IsZero() && time.Now().Add(interval).After(deadline) {
+				fmt.Printf("%s: timed out after %v\n", environment, timeout)
+				os.Exit(WaitTimedOut)
+			}
+
+			time.Sleep(interval)
+		}
+
+		fmt.Printf("%s: %s %s\n", environment, waiter.Result.Status, waiter.Result.Sha)
+		switch waiter.Result.Status {
+		case codemanager.Deployed:
+			os.Exit(WaitDeployed)
+		case codemanager.Deleted:
+			os.Exit(WaitDeleted)
+		default:
+			if waiter.Result.Error != nil {
+				fmt.Println(waiter.Result.Error.Msg)
+			}
+			os.Exit(WaitFailed)
+		}
+	},
+}
+
+// Follows an environment through status updates until it finishes deploying.
+type environmentWaiter struct {
+	Environment string
+	Sha         string
+	Done        bool
+	Result      *codemanager.Deploy
+
+	codeState codemanager.CodeState
+	// The unfinished deploy we're following. UpdateFromRawCodeState matches new
+	// records to it and updates it in place.
+	tracked *codemanager.Deploy
+	updates int
+}
+
+func (waiter *environmentWaiter) finish(deploy *codemanager.Deploy) {
+	waiter.Done = true
+	waiter.Result = deploy
+}
+
+func (waiter *environmentWaiter) Update(rawCodeState *codemanager.RawCodeState) error {
+	_, err := waiter.codeState.UpdateFromRawCodeState(rawCodeState)
+	if err != nil {
+		return err
+	}
+
+	waiter.updates++
+	waiter.check()
+	return nil
+}
+
+func (waiter *environmentWaiter) check() {
+	environmentState := waiter.codeState.Environments[waiter.Environment]
+	if environmentState == nil {
+		log.Infof("Environment %q not found; waiting", waiter.Environment)
+		return
+	}
+
+	if waiter.tracked != nil {
+		log.Debugf("Tracked deploy is now %s", waiter.tracked)
+		switch waiter.tracked.Status {
+		case codemanager.Deployed:
+			if waiter.Sha == "" || waiter.tracked.Sha == waiter.Sha {
+				waiter.finish(waiter.tracked)
+				return
+			}
+			log.Infof("Deployed %s, not %s; waiting", waiter.tracked.Sha, waiter.Sha)
+			waiter.tracked = nil
+		case codemanager.Failed, codemanager.Deleted:
+			waiter.finish(waiter.tracked)
+			return
+		case codemanager.Ghost:
+			waiter.tracked = nil
+		default:
+			return
+		}
+	}
+
+	deploys := environmentState.SortedDeploys(codemanager.Descending)
+	for _, deploy := range deploys {
+		if !deploy.Status.Finished() {
+			log.Infof("Following %s", deploy)
+			waiter.tracked = deploy
+			return
+		}
+	}
+
+	if len(deploys) == 0 {
+		return
+	}
+
+	// Nothing is in progress, so check the most recent finished deploy.
+	latest := deploys[0]
+	if latest.Status == codemanager.Deleted {
+		waiter.finish(latest)
+	} else if waiter.Sha != "" {
+		// A failed deploy of the SHA is reported rather than waiting until the
+		// timeout.
+		finished := latest.Status == codemanager.Deployed || latest.Status == codemanager.Failed
+		if finished && latest.Sha == waiter.Sha {
+			waiter.finish(latest)
+		}
+	} else if waiter.updates == 1 {
+		// Nothing was deploying when we started, so report the current status.
+		waiter.finish(latest)
+	}
+}
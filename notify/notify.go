@@ -0,0 +1,298 @@
+// Package notify sends deploy status transitions to outgoing webhooks.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"text/template"
+	"time"
+)
+
+const DefaultTimeout = 10 * time.Second
+
+// Events sent if a notifier doesn't list any.
+var DefaultEvents = []string{"new", "failed", "deleted", "ghost"}
+
+// Configuration for one outgoing webhook.
+type Config struct {
+	Name string `yaml:"name"`
+
+	// "slack" sends Slack-compatible JSON. "webhook" POSTs Body.
+	Type string `yaml:"type"`
+	Url  string `yaml:"url"`
+
+	// Glob patterns for the environments to send. Defaults to all.
+	Environments []string `yaml:"environments"`
+
+	// Names of the servers to send transitions from. Defaults to all.
+	Servers []string `yaml:"servers"`
+
+	// Transition events to send: "new" for a new environment, or a status name.
+	// Defaults to DefaultEvents.
+	Events []string `yaml:"events"`
+
+	// Template for the body of a "webhook" request, executed with an Event.
+	// Defaults to the Event as JSON. Body and ContentType can't be set for
+	// "slack" notifiers, which always send JSON.
+	Body        string        `yaml:"body"`
+	ContentType string        `yaml:"content-type"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+// The data sent for a transition.
+type Event struct {
+	codemanager.Transition
+	Server  string // "" for the default server
+	Event   string
+	Message string
+}
+
+type Notifier struct {
+	Config
+	body       *template.Template
+	httpClient *http.Client
+}
+
+// All configured notifiers.
+type Notifiers []*Notifier
+
+func New(config Config) (*Notifier, error) {
+	if config.Name == "" {
+		config.Name = config.Url
+	}
+
+	if config.Url == "" {
+		return nil, fmt.Errorf("Notifier %q: no url", config.Name)
+	}
+
+	for _, pattern := range config.Environments {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Notifier %q: invalid environment pattern %q: %v",
+				config.Name, pattern, err)
+		}
+	}
+
+	if len(config.Events) == 0 {
+		config.Events = DefaultEvents
+	}
+	for _, event := range config.Events {
+		if event == "new" {
+			continue
+		}
+		if _, err := codemanager.ParseDeployStatus(event); err != nil {
+			return nil, fmt.Errorf("Notifier %q: %v", config.Name, err)
+		}
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	notifier := &Notifier{
+		Config:     config,
+		httpClient: &http.Client{Timeout: config.Timeout},
+	}
+
+	switch config.Type {
+	case "slack":
+		if config.Body != "" || config.ContentType != "" {
+			return nil, fmt.Errorf("Notifier %q: body and content-type can't be used with type slack",
+				config.Name)
+		}
+		notifier.ContentType = "application/json"
+	case "webhook":
+		if notifier.ContentType == "" {
+			notifier.ContentType = "application/json"
+		}
+
+		if config.Body != "" {
+			var err error
+			notifier.body, err = template.New(config.Name).
+				Funcs(template.FuncMap{"json": toJson}).
+				Parse(config.Body)
+			if err != nil {
+				return nil, fmt.Errorf("Notifier %q: %v", config.Name, err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Notifier %q: invalid type %q (must be slack or webhook)",
+			config.Name, config.Type)
+	}
+
+	return notifier, nil
+}
+
+// Create notifiers for each configuration.
+func NewNotifiers(configs []Config) (Notifiers, error) {
+	notifiers := make(Notifiers, 0, len(configs))
+	for _, config := range configs {
+		notifier, err := New(config)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, notifier)
+	}
+
+	return notifiers, nil
+}
+
+func toJson(value interface{}) (string, error) {
+	bytes, err := json.Marshal(value)
+	return string(bytes), err
+}
+
+// Does this notifier want to hear about the transition?
+func (notifier *Notifier) Wants(server string, transition codemanager.Transition) bool {
+	if len(notifier.Servers) > 0 && !contains(notifier.Servers, server) {
+		return false
+	}
+
+	wanted := false
+	for _, event := range notifier.Events {
+		if event == transition.Event() {
+			wanted = true
+			break
+		}
+	}
+
+	if !wanted {
+		return false
+	}
+
+	if len(notifier.Environments) == 0 {
+		return true
+	}
+
+	for _, pattern := range notifier.Environments {
+		if matched, _ := path.Match(pattern, transition.Environment); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Send the transitions from a server that this notifier wants. A failure to
+// send one transition doesn't stop the rest; all the errors are returned.
+func (notifier *Notifier) Notify(server string, transitions []codemanager.Transition) error {
+	errs := []error{}
+	for _, transition := range transitions {
+		if !notifier.Wants(server, transition) {
+			continue
+		}
+
+		err := notifier.send(Event{
+			Transition: transition,
+			Server:     server,
+			Event:      transition.Event(),
+			Message:    Message(server, transition),
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (notifier *Notifier) send(event Event) error {
+	var body bytes.Buffer
+	var err error
+
+	switch {
+	case notifier.Type == "slack":
+		err = json.NewEncoder(&body).Encode(map[string]string{"text": event.Message})
+	case notifier.body != nil:
+		err = notifier.body.Execute(&body, event)
+	default:
+		err = json.NewEncoder(&body).Encode(event)
+	}
+	if err != nil {
+		return fmt.Errorf("Notifier %q: %v", notifier.Name, err)
+	}
+
+	log.Debugf("Notifier %q: sending %s %s", notifier.Name,
+		event.Environment, event.Event)
+	response, err := notifier.httpClient.Post(notifier.Url, notifier.ContentType, &body)
+	if err != nil {
+		return fmt.Errorf("Notifier %q: %v", notifier.Name, err)
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Notifier %q: %s returned %s",
+			notifier.Name, notifier.Url, response.Status)
+	}
+
+	return nil
+}
+
+// Send transitions to every notifier. Errors are logged rather than returned
+// so that one broken webhook doesn't stop the others.
+func (notifiers Notifiers) Notify(server string, transitions []codemanager.Transition) {
+	for _, notifier := range notifiers {
+		err := notifier.Notify(server, transitions)
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, err := range joined.Unwrap() {
+				log.Error(err)
+			}
+		} else if err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// A human readable description of a transition. The server name is included
+// unless it's the default server.
+func Message(server string, transition codemanager.Transition) string {
+	sha := ""
+	if transition.Sha != "" {
+		sha = " " + codemanager.ShortSha(transition.Sha)
+	}
+
+	var message string
+	switch {
+	case transition.NewEnvironment:
+		message = fmt.Sprintf("New environment %s (%s%s)",
+			transition.Environment, transition.To, sha)
+	case transition.To == codemanager.Failed:
+		message = fmt.Sprintf("Environment %s failed to deploy%s",
+			transition.Environment, sha)
+	case transition.To == codemanager.Deleted:
+		message = fmt.Sprintf("Environment %s was deleted", transition.Environment)
+	case transition.To == codemanager.Ghost:
+		message = fmt.Sprintf("Environment %s deploy%s disappeared while %s",
+			transition.Environment, sha, transition.From)
+	default:
+		message = fmt.Sprintf("Environment %s is %s%s",
+			transition.Environment, transition.To, sha)
+	}
+
+	if transition.Error != nil && transition.To == codemanager.Failed {
+		message += ": " + transition.Error.Msg
+	}
+
+	if server != "" {
+		message = fmt.Sprintf("[%s] %s", server, message)
+	}
+
+	return message
+}
@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	"strings"
+	"testing"
+)
+
+func TestNewRejectsSlackBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		err    string
+	}{
+		{"slack", Config{Type: "slack", Url: "http://localhost/"}, ""},
+		{"slack body", Config{Type: "slack", Url: "http://localhost/", Body: "{{.Sha}}"},
+			"can't be used with type slack"},
+		{"slack content-type", Config{Type: "slack", Url: "http://localhost/", ContentType: "text/plain"},
+			"can't be used with type slack"},
+		{"webhook body", Config{Type: "webhook", Url: "http://localhost/", Body: "{{.Sha}}"}, ""},
+		{"bad type", Config{Type: "email", Url: "http://localhost/"}, "invalid type"},
+		{"no url", Config{Type: "webhook"}, "no url"},
+		{"bad event", Config{Type: "webhook", Url: "http://localhost/", Events: []string{"exploded"}},
+			"exploded"},
+		{"bad pattern", Config{Type: "webhook", Url: "http://localhost/", Environments: []string{"["}},
+			"invalid environment pattern"},
+	}
+
+	for _, test := range tests {
+		_, err := New(test.config)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestWants(t *testing.T) {
+	failed := codemanager.Transition{Environment: "feature_x", From: codemanager.Deploying, To: codemanager.Failed}
+	deployed := codemanager.Transition{Environment: "production", From: codemanager.Deploying, To: codemanager.Deployed}
+	newEnvironment := codemanager.Transition{Environment: "feature_y", To: codemanager.Queued,
+		NewDeploy: true, NewEnvironment: true}
+
+	tests := []struct {
+		name       string
+		config     Config
+		server     string
+		transition codemanager.Transition
+		wants      bool
+	}{
+		{"default events failed", Config{}, "", failed, true},
+		{"default events deployed", Config{}, "", deployed, false},
+		{"default events new", Config{}, "", newEnvironment, true},
+		{"listed event", Config{Events: []string{"deployed"}}, "", deployed, true},
+		{"unlisted event", Config{Events: []string{"deployed"}}, "", failed, false},
+		{"new isn't its status", Config{Events: []string{"queued"}}, "", newEnvironment, false},
+		{"matching glob", Config{Environments: []string{"feature_*"}}, "", failed, true},
+		{"exact name", Config{Environments: []string{"production"}, Events: []string{"deployed"}},
+			"", deployed, true},
+		{"no matching glob", Config{Environments: []string{"production", "release_*"}}, "", failed, false},
+		{"listed server", Config{Servers: []string{"prod"}}, "prod", failed, true},
+		{"unlisted server", Config{Servers: []string{"prod"}}, "dr", failed, false},
+		{"default server", Config{Servers: []string{"prod"}}, "", failed, false},
+		{"any server", Config{}, "dr", failed, true},
+	}
+
+	for _, test := range tests {
+		test.config.Type = "webhook"
+		test.config.Url = "http://localhost/"
+		notifier, err := New(test.config)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if wants := notifier.Wants(test.server, test.transition); wants != test.wants {
+			t.Errorf("%s: Wants() = %v, expected %v", test.name, wants, test.wants)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	sha := "0123456789abcdef0123456789abcdef01234567"
+
+	tests := []struct {
+		server     string
+		transition codemanager.Transition
+		message    string
+	}{
+		{"", codemanager.Transition{Environment: "feature_y", Sha: sha, To: codemanager.Queued,
+			NewDeploy: true, NewEnvironment: true},
+			"New environment feature_y (queued 01234567)"},
+		{"", codemanager.Transition{Environment: "production", Sha: sha,
+			From: codemanager.Deploying, To: codemanager.Failed,
+			Error: &codemanager.DeployError{Msg: "Puppetfile is broken"}},
+			"Environment production failed to deploy 01234567: Puppetfile is broken"},
+		{"", codemanager.Transition{Environment: "production", From: codemanager.Deployed, To: codemanager.Deleted},
+			"Environment production was deleted"},
+		{"", codemanager.Transition{Environment: "production", Sha: "abc",
+			From: codemanager.Deploying, To: codemanager.Ghost},
+			"Environment production deploy abc disappeared while deploying"},
+		{"", codemanager.Transition{Environment: "production", Sha: sha,
+			From: codemanager.Deploying, To: codemanager.Deployed},
+			"Environment production is deployed 01234567"},
+		{"", codemanager.Transition{Environment: "production", From: codemanager.Queued, To: codemanager.Deploying},
+			"Environment production is deploying"},
+		{"dr", codemanager.Transition{Environment: "production", Sha: sha,
+			From: codemanager.Deploying, To: codemanager.Deployed},
+			"[dr] Environment production is deployed 01234567"},
+	}
+
+	for _, test := range tests {
+		if message := Message(test.server, test.transition); message != test.message {
+			t.Errorf("Message() = %q, expected %q", message, test.message)
+		}
+	}
+}
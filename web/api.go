@@ -0,0 +1,205 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/buaazp/fasthttprouter"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"time"
+)
+
+// JSON API. All endpoints accept the following query parameters:
+//
+//	server: name of the Code Manager server; defaults to the first
+//	status: comma-separated list of deploy statuses, e.g. "failed,ghost"
+//	category: comma-separated list of failure categories, e.g. "timeout"
+//	sha:    only include deploys of SHAs starting with this
+//	since:  only include deploys at or after this RFC 3339 time
+//	until:  only include deploys at or before this RFC 3339 time
+//	limit:  maximum number of deploys to return per environment
+func addApiRoutes(router *fasthttprouter.Router, readOnly authWrapper) {
+	router.GET("/api/v1/environments", readOnly(ApiEnvironments))
+	router.GET("/api/v1/environments/:name", readOnly(ApiEnvironment))
+	router.GET("/api/v1/environments/:name/deploys", readOnly(ApiEnvironmentDeploys))
+}
+
+// Wraps a handler to require a role.
+type authWrapper func(fasthttp.RequestHandler) fasthttp.RequestHandler
+
+type apiError struct {
+	Error string
+}
+
+type deployQuery struct {
+	Filter codemanager.DeployFilter
+	Limit  int
+}
+
+func renderJson(ctx *fasthttp.RequestCtx, statusCode int, value interface{}) {
+	body, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		ctx.SetStatusCode(500)
+		fmt.Fprintf(ctx, "Error encoding JSON: %v", err)
+		log.Errorf("%v: encoding JSON %v", ctx.URI(), err)
+		return
+	}
+
+	ctx.SetStatusCode(statusCode)
+	ctx.SetContentType("application/json; charset=utf-8")
+	ctx.Write(append(body, '\n'))
+}
+
+func renderJsonError(ctx *fasthttp.RequestCtx, statusCode int, format string, args ...interface{}) {
+	renderJson(ctx, statusCode, apiError{Error: fmt.Sprintf(format, args...)})
+}
+
+func parseQueryTime(ctx *fasthttp.RequestCtx, name string) (time.Time, error) {
+	value := string(ctx.QueryArgs().Peek(name))
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return parsed, fmt.Errorf("Invalid %s: %q is not an RFC 3339 time", name, value)
+	}
+
+	return parsed, nil
+}
+
+func parseDeployQuery(ctx *fasthttp.RequestCtx) (deployQuery, error) {
+	query := deployQuery{}
+	var err error
+
+	args := ctx.QueryArgs()
+	query.Filter.Statuses, err = codemanager.ParseDeployStatuses(string(args.Peek("status")))
+	if err != nil {
+		return query, err
+	}
+
+	query.Filter.Categories = codemanager.ParseList(string(args.Peek("category")))
+	query.Filter.Sha = string(args.Peek("sha"))
+
+	query.Filter.Since, err = parseQueryTime(ctx, "since")
+	if err != nil {
+		return query, err
+	}
+
+	query.Filter.Until, err = parseQueryTime(ctx, "until")
+	if err != nil {
+		return query, err
+	}
+
+	if args.Has("limit") {
+		query.Limit, err = strconv.Atoi(string(args.Peek("limit")))
+		if err != nil || query.Limit < 1 {
+			return query, fmt.Errorf("Invalid limit %q", args.Peek("limit"))
+		}
+	}
+
+	return query, nil
+}
+
+func (query *deployQuery) apply(environmentState *codemanager.EnvironmentState) []*codemanager.Deploy {
+	deploys := environmentState.FilterDeploys(query.Filter)
+	if query.Limit > 0 && len(deploys) > query.Limit {
+		deploys = deploys[:query.Limit]
+	}
+
+	return deploys
+}
+
+// Look up the server named in the query, or render a 404.
+func getApiRequestServer(ctx *fasthttp.RequestCtx) *monitoredServer {
+	monitored := requestServer(ctx)
+	if monitored == nil {
+		renderJsonError(ctx, 404, "No such server %q", ctx.QueryArgs().Peek("server"))
+	}
+
+	return monitored
+}
+
+// Look up the environment named in the URL, or render a 404.
+func getRequestEnvironment(ctx *fasthttp.RequestCtx) *codemanager.EnvironmentState {
+	monitored := getApiRequestServer(ctx)
+	if monitored == nil {
+		return nil
+	}
+
+	name := ctx.UserValue("name").(string)
+	environmentState := monitored.getCodeState().Environments[name]
+	if environmentState == nil {
+		renderJsonError(ctx, 404, "No such environment %q", name)
+	}
+
+	return environmentState
+}
+
+// Environments that have at least one deploy matching the query
+func ApiEnvironments(ctx *fasthttp.RequestCtx) {
+	log.Infof("ApiEnvironments: %v", ctx.URI())
+
+	query, err := parseDeployQuery(ctx)
+	if err != nil {
+		renderJsonError(ctx, 400, "%v", err)
+		return
+	}
+
+	monitored := getApiRequestServer(ctx)
+	if monitored == nil {
+		return
+	}
+
+	environments := []codemanager.EnvironmentState{}
+	for _, environmentState := range monitored.getCodeState().SortedEnvironments() {
+		deploys := query.apply(environmentState)
+		if len(deploys) > 0 {
+			environments = append(environments, codemanager.EnvironmentState{
+				Environment: environmentState.Environment,
+				Deploys:     deploys,
+			})
+		}
+	}
+
+	renderJson(ctx, 200, environments)
+}
+
+func ApiEnvironment(ctx *fasthttp.RequestCtx) {
+	log.Infof("ApiEnvironment: %v", ctx.URI())
+
+	query, err := parseDeployQuery(ctx)
+	if err != nil {
+		renderJsonError(ctx, 400, "%v", err)
+		return
+	}
+
+	environmentState := getRequestEnvironment(ctx)
+	if environmentState == nil {
+		return
+	}
+
+	renderJson(ctx, 200, codemanager.EnvironmentState{
+		Environment: environmentState.Environment,
+		Deploys:     query.apply(environmentState),
+	})
+}
+
+func ApiEnvironmentDeploys(ctx *fasthttp.RequestCtx) {
+	log.Infof("ApiEnvironmentDeploys: %v", ctx.URI())
+
+	query, err := parseDeployQuery(ctx)
+	if err != nil {
+		renderJsonError(ctx, 400, "%v", err)
+		return
+	}
+
+	environmentState := getRequestEnvironment(ctx)
+	if environmentState == nil {
+		return
+	}
+
+	renderJson(ctx, 200, query.apply(environmentState))
+}
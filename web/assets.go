@@ -0,0 +1,72 @@
+package web
+
+import (
+	"embed"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"strings"
+)
+
+// Templates and static files built into the binary
+//
+//go:embed templates static
+var embeddedAssets embed.FS
+
+// Get templates and static files from dir if it's not "", otherwise use the
+// copies built into the binary.
+func getAssets(dir string) fs.FS {
+	if dir == "" {
+		return embeddedAssets
+	}
+
+	log.Infof("Loading templates and static files from %q", dir)
+	return os.DirFS(dir)
+}
+
+// Loads jet templates from an fs.FS. Template names are absolute paths within
+// the templates directory, e.g. "/home.jet".
+type templateLoader struct {
+	assets fs.FS
+}
+
+func (loader templateLoader) path(name string) string {
+	return path.Join("templates", path.Clean("/"+name))
+}
+
+func (loader templateLoader) Open(name string) (io.ReadCloser, error) {
+	return loader.assets.Open(loader.path(name))
+}
+
+func (loader templateLoader) Exists(name string) (string, bool) {
+	_, err := fs.Stat(loader.assets, loader.path(name))
+	return name, err == nil
+}
+
+// Serve files from the static directory.
+func staticHandler(assets fs.FS) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		name := path.Join("static", path.Clean("/"+ctx.UserValue("filepath").(string)))
+		data, err := fs.ReadFile(assets, name)
+		if err != nil {
+			ctx.SetStatusCode(404)
+			ctx.SetBodyString("Not found")
+			return
+		}
+
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		if strings.HasPrefix(contentType, "text/") && !strings.Contains(contentType, "charset") {
+			contentType += "; charset=utf-8"
+		}
+
+		ctx.SetContentType(contentType)
+		ctx.SetBody(data)
+	}
+}
@@ -0,0 +1,94 @@
+package web
+
+import (
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"sort"
+)
+
+// One environment on every server.
+type combinedEnvironment struct {
+	Environment string
+	Servers     []combinedServerEnvironment // In the same order as server.Servers
+
+	// Deployed at different SHAs on different servers
+	Mismatched bool
+}
+
+// One environment on one server.
+type combinedServerEnvironment struct {
+	Server *monitoredServer
+	Latest *codemanager.Deploy // nil if the server doesn't have the environment
+	Sha    string              // The deployed SHA, or "" if it was never deployed
+}
+
+type combinedPage struct {
+	Environments   []combinedEnvironment
+	MismatchedOnly bool
+	Mismatches     int
+}
+
+// Compare each environment across all servers.
+func combineServers(servers []*monitoredServer) []combinedEnvironment {
+	codeStates := make([]*codemanager.CodeState, len(servers))
+	names := map[string]bool{}
+	for i, monitored := range servers {
+		codeStates[i] = monitored.getCodeState()
+		for name := range codeStates[i].Environments {
+			names[name] = true
+		}
+	}
+
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	environments := make([]combinedEnvironment, len(sortedNames))
+	for i, name := range sortedNames {
+		environment := combinedEnvironment{Environment: name}
+		shas := map[string]bool{}
+
+		for j, monitored := range servers {
+			entry := combinedServerEnvironment{Server: monitored}
+			if environmentState := codeStates[j].Environments[name]; environmentState != nil {
+				entry.Latest = environmentState.Latest()
+				entry.Sha = codeStates[j].DeployedSha(name)
+			}
+
+			if entry.Sha != "" {
+				shas[entry.Sha] = true
+			}
+			environment.Servers = append(environment.Servers, entry)
+		}
+
+		environment.Mismatched = len(shas) > 1
+		environments[i] = environment
+	}
+
+	return environments
+}
+
+// Every environment on every server, flagging environments that are deployed
+// at different SHAs on different servers.
+func Servers(ctx *fasthttp.RequestCtx) {
+	log.Infof("Servers: %v", ctx.URI())
+
+	page := combinedPage{
+		MismatchedOnly: string(ctx.QueryArgs().Peek("mismatched")) != "",
+	}
+
+	for _, environment := range combineServers(server.Servers) {
+		if environment.Mismatched {
+			page.Mismatches++
+		} else if page.MismatchedOnly {
+			continue
+		}
+		page.Environments = append(page.Environments, environment)
+	}
+
+	// Errors are handled within render
+	render(ctx, "servers.jet", page)
+}
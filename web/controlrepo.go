@@ -0,0 +1,48 @@
+package web
+
+import (
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	"time"
+)
+
+// Signals refreshControlRepo that the state has changed.
+var controlRepoWanted = make(chan struct{}, 1)
+
+// Ask refreshControlRepo to look up new commits without waiting for it.
+func wantControlRepoRefresh() {
+	select {
+	case controlRepoWanted <- struct{}{}:
+	default:
+	}
+}
+
+// Look up the commits deployed on every server forever, fetching the control
+// repo when some are missing, so that requests and events don't have to wait
+// for git. Runs every ControlRepoFetchInterval, and whenever the state changes.
+func refreshControlRepo() {
+	ticker := time.NewTicker(codemanager.ControlRepoFetchInterval)
+	defer ticker.Stop()
+
+	for {
+		shas := []string{}
+		for _, monitored := range server.Servers {
+			shas = append(shas, monitored.getCodeState().Shas()...)
+		}
+		server.ControlRepo.Refresh(shas)
+
+		for _, monitored := range server.Servers {
+			codeState := monitored.getCodeState()
+			for _, environmentState := range codeState.Environments {
+				compareToBase(codeState, environmentState)
+			}
+
+			// Events only use cached lookups, so send anything that was missing.
+			monitored.republish()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-controlRepoWanted:
+		}
+	}
+}
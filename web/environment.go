@@ -0,0 +1,27 @@
+package web
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+)
+
+func Environment(ctx *fasthttp.RequestCtx) {
+	log.Infof("Environment: %v", ctx.URI())
+
+	monitored := getRequestServer(ctx)
+	if monitored == nil {
+		return
+	}
+
+	name := ctx.UserValue("name").(string)
+	environmentState := monitored.getCodeState().Environments[name]
+	if environmentState == nil {
+		ctx.SetStatusCode(404)
+		fmt.Fprintf(ctx, "No such environment %q", name)
+		return
+	}
+
+	// Errors are handled within render
+	render(ctx, "environment.jet", environmentState)
+}
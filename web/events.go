@@ -0,0 +1,219 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// How often to send a comment to keep idle connections open.
+const eventsKeepAlive = 30 * time.Second
+
+// What the home page shows for one environment.
+type environmentSummary struct {
+	Environment string
+	Status      string
+	Time        string
+	Lagging     []laggingCompiler
+
+	// Only set if there's a control repo
+	Commit     string
+	Comparison string
+}
+
+type laggingCompiler struct {
+	Name        string
+	Sha         string
+	CheckedInAt string
+}
+
+// Must match the format used in the templates.
+func formatEventTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05 -0700")
+}
+
+func summarizeEnvironment(codeState *codemanager.CodeState, environmentState *codemanager.EnvironmentState) environmentSummary {
+	summary := environmentSummary{
+		Environment: environmentState.Environment,
+		Lagging:     []laggingCompiler{},
+	}
+
+	if deploy := environmentState.Latest(); deploy != nil {
+		summary.Status = deploy.Status.String()
+		summary.Time = formatEventTime(deploy.MatchTime())
+	}
+
+	// This is called with locks held, so only use what refreshControlRepo has
+	// already looked up rather than waiting for git.
+	if deployed := environmentState.LatestDeployed(); deployed != nil {
+		if commit := server.ControlRepo.CachedCommit(deployed.Sha); commit != nil {
+			summary.Commit = commit.Subject
+		}
+	}
+
+	sha, baseSha := comparedShas(codeState, environmentState)
+	if comparison := server.ControlRepo.CachedCompare(sha, baseSha); comparison != nil {
+		summary.Comparison = fmt.Sprintf("%s compared to %s",
+			comparison, server.ControlRepoBase)
+	}
+
+	for _, compiler := range environmentState.LaggingCompilers() {
+		summary.Lagging = append(summary.Lagging, laggingCompiler{
+			Name:        compiler.Name,
+			Sha:         compiler.Sha,
+			CheckedInAt: formatEventTime(compiler.CheckedInAt),
+		})
+	}
+
+	return summary
+}
+
+// Sends changes to a server's environment summaries to clients of /events.
+type eventBroker struct {
+	lock        sync.Mutex
+	subscribers map[chan []byte]bool
+	summaries   map[string]environmentSummary
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subscribers: map[chan []byte]bool{},
+		summaries:   map[string]environmentSummary{},
+	}
+}
+
+func (broker *eventBroker) subscribe() chan []byte {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	events := make(chan []byte, 64)
+	broker.subscribers[events] = true
+	return events
+}
+
+func (broker *eventBroker) unsubscribe(events chan []byte) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	delete(broker.subscribers, events)
+}
+
+// Compare the new state to the last one, and send an event for every
+// environment that looks different.
+func (broker *eventBroker) publish(codeState *codemanager.CodeState) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	summaries := make(map[string]environmentSummary, len(codeState.Environments))
+	for _, environmentState := range codeState.SortedEnvironments() {
+		summary := summarizeEnvironment(codeState, environmentState)
+		summaries[summary.Environment] = summary
+
+		old, found := broker.summaries[summary.Environment]
+		if found && reflect.DeepEqual(old, summary) {
+			continue
+		}
+
+		broker.send("environment", summary)
+	}
+
+	for name := range broker.summaries {
+		if _, found := summaries[name]; !found {
+			broker.send("removed", environmentSummary{Environment: name})
+		}
+	}
+
+	broker.summaries = summaries
+}
+
+// What the page shows about polling.
+type pollSummary struct {
+	Failing     bool
+	LastSuccess string
+	LastError   string
+	LastErrorAt string
+}
+
+// Tell clients how the last poll went.
+func (broker *eventBroker) publishPollStatus(status pollStatus) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	summary := pollSummary{
+		Failing:   status.Failing(),
+		LastError: status.LastError,
+	}
+	if !status.LastSuccess.IsZero() {
+		summary.LastSuccess = formatEventTime(status.LastSuccess)
+	}
+	if !status.LastErrorAt.IsZero() {
+		summary.LastErrorAt = formatEventTime(status.LastErrorAt)
+	}
+
+	broker.send("poll", summary)
+}
+
+// Must be called with the lock held.
+func (broker *eventBroker) send(eventType string, value interface{}) {
+	if len(broker.subscribers) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Errorf("Encoding %s event: %v", eventType, err)
+		return
+	}
+
+	message := []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, data))
+	for events := range broker.subscribers {
+		select {
+		case events <- message:
+		default:
+			log.Warn("Events client is too slow; dropping event")
+		}
+	}
+}
+
+// Server-sent events with updates for the home page
+func Events(ctx *fasthttp.RequestCtx) {
+	log.Infof("Events: %v", ctx.URI())
+
+	monitored := getRequestServer(ctx)
+	if monitored == nil {
+		return
+	}
+	broker := monitored.broker
+
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+
+	events := broker.subscribe()
+	ctx.SetBodyStreamWriter(func(writer *bufio.Writer) {
+		defer broker.unsubscribe(events)
+
+		keepAlive := time.NewTicker(eventsKeepAlive)
+		defer keepAlive.Stop()
+
+		// Make sure the headers go out immediately.
+		writer.WriteString(": connected\n\n")
+		for {
+			if err := writer.Flush(); err != nil {
+				log.Debugf("Events client disconnected: %v", err)
+				return
+			}
+
+			select {
+			case message := <-events:
+				writer.Write(message)
+			case <-keepAlive.C:
+				writer.WriteString(": keep-alive\n\n")
+			}
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Writes metrics in the Prometheus text exposition format.
+type metricsWriter struct {
+	buffer bytes.Buffer
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Write HELP and TYPE for a metric. This must come before its samples.
+func (writer *metricsWriter) describe(name string, metricType string, help string) {
+	fmt.Fprintf(&writer.buffer, "# HELP %s %s\n# TYPE %s %s\n",
+		name, help, name, metricType)
+}
+
+// Write a sample. labels are name, value pairs.
+func (writer *metricsWriter) sample(name string, value float64, labels ...string) {
+	writer.buffer.WriteString(name)
+	if len(labels) > 0 {
+		writer.buffer.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				writer.buffer.WriteByte(',')
+			}
+			fmt.Fprintf(&writer.buffer, `%s="%s"`,
+				labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		writer.buffer.WriteByte('}')
+	}
+	fmt.Fprintf(&writer.buffer, " %g\n", value)
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// Every metric has a server label, which is empty for the default server.
+func Metrics(ctx *fasthttp.RequestCtx) {
+	log.Debugf("Metrics: %v", ctx.URI())
+
+	writer := metricsWriter{}
+	now := time.Now()
+
+	// Load each server's state once so that all metrics are consistent.
+	environments := make([][]*codemanager.EnvironmentState, len(server.Servers))
+	for i, monitored := range server.Servers {
+		environments[i] = monitored.getCodeState().SortedEnvironments()
+	}
+
+	writer.describe("code_manager_environment_status", "gauge",
+		"1 if the environment's most recent deploy has the status in the status label, otherwise 0.")
+	for i, monitored := range server.Servers {
+		for _, environmentState := range environments[i] {
+			latest := environmentState.Latest()
+			if latest == nil {
+				continue
+			}
+
+			current := latest.Status
+			for _, name := range codemanager.DeployStatusNames {
+				writer.sample("code_manager_environment_status",
+					boolToFloat(current.String() == name),
+					"server", monitored.Name,
+					"environment", environmentState.Environment, "status", name)
+			}
+		}
+	}
+
+	writer.describe("code_manager_environment_last_success_timestamp_seconds", "gauge",
+		"When the environment was last deployed successfully.")
+	for i, monitored := range server.Servers {
+		for _, environmentState := range environments[i] {
+			deploy := environmentState.LatestDeployed()
+			if deploy != nil && deploy.HasFinishedTime() {
+				writer.sample("code_manager_environment_last_success_timestamp_seconds",
+					unixSeconds(deploy.FinishedAt),
+					"server", monitored.Name, "environment", environmentState.Environment)
+			}
+		}
+	}
+
+	writer.describe("code_manager_environment_deploys", "gauge",
+		"Number of recorded deploys of the environment with the status in the status label.")
+	for i, monitored := range server.Servers {
+		for _, environmentState := range environments[i] {
+			counts := map[codemanager.DeployStatus]int{}
+			for _, deploy := range environmentState.Deploys {
+				counts[deploy.Status]++
+			}
+
+			for _, status := range []codemanager.DeployStatus{codemanager.Failed, codemanager.Ghost} {
+				writer.sample("code_manager_environment_deploys", float64(counts[status]),
+					"server", monitored.Name,
+					"environment", environmentState.Environment, "status", status.String())
+			}
+		}
+	}
+
+	writer.describe("code_manager_compiler_sync_lag_seconds", "gauge",
+		"Time since the environment was deployed if the compiler doesn't have it yet, otherwise 0.")
+	checkIns := make([]map[string]time.Time, len(server.Servers))
+	for i, monitored := range server.Servers {
+		checkIns[i] = map[string]time.Time{}
+		for _, environmentState := range environments[i] {
+			deploy := environmentState.LatestDeployed()
+			if deploy == nil {
+				continue
+			}
+
+			for _, compiler := range deploy.Compilers {
+				lag := 0.0
+				if !deploy.SyncedTo(&compiler) && deploy.HasFinishedTime() {
+					lag = now.Sub(deploy.FinishedAt).Seconds()
+				}
+
+				writer.sample("code_manager_compiler_sync_lag_seconds", lag,
+					"server", monitored.Name,
+					"environment", environmentState.Environment, "compiler", compiler.Name)
+
+				if compiler.CheckedInAt.After(checkIns[i][compiler.Name]) {
+					checkIns[i][compiler.Name] = compiler.CheckedInAt
+				}
+			}
+		}
+	}
+
+	writer.describe("code_manager_compiler_last_check_in_timestamp_seconds", "gauge",
+		"When the compiler last checked in with file sync storage.")
+	for i, monitored := range server.Servers {
+		compilerNames := make([]string, 0, len(checkIns[i]))
+		for name := range checkIns[i] {
+			compilerNames = append(compilerNames, name)
+		}
+		sort.Strings(compilerNames)
+		for _, name := range compilerNames {
+			writer.sample("code_manager_compiler_last_check_in_timestamp_seconds",
+				unixSeconds(checkIns[i][name]), "server", monitored.Name, "compiler", name)
+		}
+	}
+
+	writer.describe("code_manager_polls_total", "counter",
+		"Attempts to poll the Code Manager API.")
+	for _, monitored := range server.Servers {
+		status := monitored.getPollStatus()
+		if !status.Polling {
+			continue
+		}
+
+		writer.sample("code_manager_polls_total", float64(status.Successes),
+			"server", monitored.Name, "result", "success")
+		writer.sample("code_manager_polls_total", float64(status.Failures),
+			"server", monitored.Name, "result", "failure")
+	}
+
+	writer.describe("code_manager_last_poll_success_timestamp_seconds", "gauge",
+		"When the Code Manager API was last polled successfully.")
+	for _, monitored := range server.Servers {
+		status := monitored.getPollStatus()
+		if !status.LastSuccess.IsZero() {
+			writer.sample("code_manager_last_poll_success_timestamp_seconds",
+				float64(status.LastSuccess.Unix()), "server", monitored.Name)
+		}
+	}
+
+	ctx.SetContentType("text/plain; version=0.0.4; charset=utf-8")
+	ctx.Write(writer.buffer.Bytes())
+}
@@ -0,0 +1,90 @@
+package web
+
+import (
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Poll the server's Code Manager API forever, updating the state file and the
+// state being served after each request.
+func (monitored *monitoredServer) poll(interval time.Duration) {
+	apiClient := monitored.ApiClient
+	log.Infof("Polling %s:%d every %v", apiClient.Host, apiClient.Port, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := monitored.pollOnce()
+		if err != nil {
+			log.Errorf("Polling Code Manager %s: %v", monitored.DisplayName(), err)
+		}
+		monitored.setPollResult(time.Now(), err)
+
+		<-ticker.C
+	}
+}
+
+// The results of recent polls, shown in the web UI and in metrics.
+type pollStatus struct {
+	Polling     bool
+	Successes   uint64
+	Failures    uint64
+	LastSuccess time.Time
+	LastError   string
+	LastErrorAt time.Time
+}
+
+// Did the most recent poll fail?
+func (status pollStatus) Failing() bool {
+	return status.LastError != "" && status.LastErrorAt.After(status.LastSuccess)
+}
+
+func (monitored *monitoredServer) getPollStatus() pollStatus {
+	monitored.pollStatusLock.RLock()
+	defer monitored.pollStatusLock.RUnlock()
+	return monitored.pollStatus
+}
+
+func (monitored *monitoredServer) setPollResult(at time.Time, err error) {
+	monitored.pollStatusLock.Lock()
+	if err == nil {
+		monitored.pollStatus.Successes++
+		monitored.pollStatus.LastSuccess = at
+	} else {
+		monitored.pollStatus.Failures++
+		monitored.pollStatus.LastError = err.Error()
+		monitored.pollStatus.LastErrorAt = at
+	}
+	status := monitored.pollStatus
+	monitored.pollStatusLock.Unlock()
+
+	monitored.broker.publishPollStatus(status)
+}
+
+func (monitored *monitoredServer) pollOnce() error {
+	log.Debugf("Polling Code Manager %s", monitored.DisplayName())
+	rawCodeState, err := monitored.ApiClient.GetRawCodeState()
+	if err != nil {
+		return err
+	}
+
+	// Start from the stored state rather than the state in memory, so that
+	// changes made by other commands (e.g. trim) aren't lost. This also means we
+	// never modify the CodeState that requests are reading.
+	var transitions []codemanager.Transition
+	err = monitored.updateCodeState(func(codeState *codemanager.CodeState) error {
+		transitions, err = codeState.UpdateFromRawCodeState(rawCodeState)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Don't hold up polling for slow webhooks.
+	if len(server.Notifiers) > 0 && len(transitions) > 0 {
+		go server.Notifiers.Notify(monitored.Name, transitions)
+	}
+	return nil
+}
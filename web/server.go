@@ -1,43 +1,154 @@
 package web
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/CloudyKit/jet"
 	"github.com/buaazp/fasthttprouter"
+	"github.com/danielparks/code-manager-dashboard/auth"
 	"github.com/danielparks/code-manager-dashboard/codemanager"
+	"github.com/danielparks/code-manager-dashboard/notify"
 	log "github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
+	"os"
+	"time"
 )
 
+type Options struct {
+	ListenOn string
+
+	// Serve HTTPS if TlsCert and TlsKey are set. If TlsClientCa is also set,
+	// clients must present a certificate signed by a CA in that bundle.
+	TlsCert     string
+	TlsKey      string
+	TlsClientCa string
+
+	// Load templates and static files from this directory instead of using the
+	// copies built into the binary. Templates are reloaded on every request.
+	DevAssets string
+
+	// The Code Manager servers to serve. The first is shown by default.
+	Servers []ServerOptions
+
+	// Poll each server's API every PollInterval. Polling is disabled for
+	// servers without an ApiClient, or if PollInterval is 0.
+	PollInterval time.Duration
+
+	// Send status transitions seen while polling.
+	Notifiers notify.Notifiers
+
+	// Look up deployed commits in ControlRepo if it's not nil, and compare
+	// environments to the commit deployed in ControlRepoBase.
+	ControlRepo     *codemanager.ControlRepo
+	ControlRepoBase string
+
+	// Apply Retention every TrimInterval. Disabled if Retention is nil or
+	// TrimInterval is 0.
+	Retention    *codemanager.Retention
+	TrimInterval time.Duration
+
+	// Who may view the dashboard. If nil, anyone may view it.
+	Auth *auth.Authenticators
+}
+
+// One Code Manager server to serve.
+type ServerOptions struct {
+	Name      string // "" for the default server
+	Store     codemanager.Store
+	ApiClient *codemanager.ApiClient // Used to poll. May be nil.
+}
+
 type webServer struct {
-	StateFilePath string
-	CodeState     *codemanager.CodeState
-	View          *jet.Set
+	Servers   []*monitoredServer
+	View      *jet.Set
+	Notifiers notify.Notifiers
+
+	ControlRepo     *codemanager.ControlRepo
+	ControlRepoBase string
 }
 
 var server webServer
 
-func Serve(listenOn string, stateFilePath string) {
-	/// FIXME bindata
+func Serve(options Options) {
+	if len(options.Servers) == 0 {
+		log.Fatal("No servers to serve")
+	}
+
+	assets := getAssets(options.DevAssets)
 	server = webServer{
-		View:          jet.NewHTMLSet("./web/templates"),
-		StateFilePath: stateFilePath,
+		View:      jet.NewHTMLSetLoader(templateLoader{assets}),
+		Notifiers: options.Notifiers,
+
+		ControlRepo:     options.ControlRepo,
+		ControlRepoBase: options.ControlRepoBase,
 	}
+	server.View.SetDevelopmentMode(options.DevAssets != "")
 
-	codeState, err := codemanager.LoadCodeState(stateFilePath)
-	if err != nil {
-		log.Fatal(err)
+	for _, serverOptions := range options.Servers {
+		monitored := &monitoredServer{
+			Name:      serverOptions.Name,
+			Store:     serverOptions.Store,
+			ApiClient: serverOptions.ApiClient,
+			broker:    newEventBroker(),
+		}
+		server.Servers = append(server.Servers, monitored)
+
+		polling := monitored.ApiClient != nil && options.PollInterval > 0
+
+		codeState, err := monitored.Store.Load()
+		if polling && os.IsNotExist(err) {
+			// The poller will create it.
+			err = nil
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		monitored.setCodeState(&codeState)
+
+		if polling {
+			monitored.pollStatus.Polling = true
+			go monitored.poll(options.PollInterval)
+		}
+	}
+
+	if options.Retention != nil && options.TrimInterval > 0 {
+		go trim(*options.Retention, options.TrimInterval)
 	}
 
-	server.CodeState = &codeState
+	if server.ControlRepo != nil {
+		go refreshControlRepo()
+	}
+
+	authenticators := options.Auth
+	if authenticators == nil {
+		authenticators = &auth.Authenticators{AnonymousRole: auth.ReadOnly}
+	}
+	readOnly := func(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return authenticators.Require(auth.ReadOnly, handler)
+	}
 
 	router := fasthttprouter.New()
-	router.GET("/", Home)
-	/// FIXME bindata
-	router.ServeFiles("/static/*filepath", "web/static")
+	router.GET("/", readOnly(Home))
+	router.GET("/environments/:name", readOnly(Environment))
+	router.GET("/servers", readOnly(Servers))
+	router.GET("/stats", readOnly(Stats))
+	router.GET("/metrics", readOnly(Metrics))
+	router.GET("/events", readOnly(Events))
+	addApiRoutes(router, readOnly)
+	router.GET("/static/*filepath", staticHandler(assets))
+
+	log.Fatal(listenAndServe(&options, logClient(router.Handler)))
+}
 
-	log.Infof("Listening on %v", listenOn)
-	log.Fatal(fasthttp.ListenAndServe(listenOn, router.Handler))
+// Log the client certificate, if there is one.
+func logClient(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if name := clientCertificateName(ctx); name != "" {
+			log.Debugf("Request from %q: %s %s", name, ctx.Method(), ctx.URI())
+		}
+		handler(ctx)
+	}
 }
 
 func render(ctx *fasthttp.RequestCtx, templateName string, context interface{}) error {
@@ -52,6 +163,27 @@ func render(ctx *fasthttp.RequestCtx, templateName string, context interface{})
 	vars := make(jet.VarMap)
 	vars.Set("Ascending", codemanager.Ascending)
 	vars.Set("Descending", codemanager.Descending)
+	vars.Set("formatDuration", codemanager.FormatDuration)
+	vars.Set("formatPercent", formatPercent)
+	vars.Set("formatJson", formatJson)
+	vars.Set("shortSha", codemanager.ShortSha)
+	vars.Set("controlRepo", server.ControlRepo != nil)
+	vars.Set("controlRepoBase", server.ControlRepoBase)
+	vars.Set("commit", server.ControlRepo.Commit)
+	vars.Set("identity", auth.RequestIdentity(ctx))
+
+	// Pages that aren't about one server get the first server.
+	monitored := requestServer(ctx)
+	if monitored == nil {
+		monitored = server.Servers[0]
+	}
+	vars.Set("servers", server.Servers)
+	vars.Set("currentServer", monitored)
+	vars.Set("link", monitored.Link)
+	vars.Set("pollStatus", monitored.getPollStatus())
+	vars.Set("compareToBase", func(environmentState *codemanager.EnvironmentState) *codemanager.Comparison {
+		return compareToBase(monitored.getCodeState(), environmentState)
+	})
 
 	err = template.Execute(ctx, vars, context)
 	if err != nil {
@@ -65,9 +197,45 @@ func render(ctx *fasthttp.RequestCtx, templateName string, context interface{})
 	return nil
 }
 
+// Compare an environment's deployed commit to the base environment's on the
+// same server. Returns nil if there's nothing to compare.
+func compareToBase(codeState *codemanager.CodeState, environmentState *codemanager.EnvironmentState) *codemanager.Comparison {
+	sha, baseSha := comparedShas(codeState, environmentState)
+	return server.ControlRepo.Compare(sha, baseSha)
+}
+
+// The environment's deployed SHA and the base environment's deployed SHA, or
+// "" for either if there's nothing to compare.
+func comparedShas(codeState *codemanager.CodeState, environmentState *codemanager.EnvironmentState) (string, string) {
+	if server.ControlRepo == nil || environmentState.Environment == server.ControlRepoBase {
+		return "", ""
+	}
+
+	deploy := environmentState.LatestDeployed()
+	if deploy == nil {
+		return "", ""
+	}
+
+	return deploy.Sha, codeState.DeployedSha(server.ControlRepoBase)
+}
+
+// Indented JSON for display
+func formatJson(value interface{}) string {
+	indented, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(indented)
+}
+
 func Home(ctx *fasthttp.RequestCtx) {
 	log.Infof("Home: %v", ctx.URI())
 
+	monitored := getRequestServer(ctx)
+	if monitored == nil {
+		return
+	}
+
 	// Errors are handled within render
-	render(ctx, "home.jet", server.CodeState)
+	render(ctx, "home.jet", monitored.getCodeState())
 }
@@ -0,0 +1,121 @@
+package web
+
+import (
+	"fmt"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	"github.com/valyala/fasthttp"
+	"net/url"
+	"sync"
+)
+
+// A Code Manager server whose state is being served.
+type monitoredServer struct {
+	Name      string // "" for the default server
+	Store     codemanager.Store
+	ApiClient *codemanager.ApiClient // Used to poll. May be nil.
+
+	broker *eventBroker
+
+	// Protects codeState, which is replaced by the poller.
+	codeState     *codemanager.CodeState
+	codeStateLock sync.RWMutex
+
+	// Held while updating the store, so that codeState is replaced in the
+	// same order the updates were made.
+	updateLock sync.Mutex
+
+	pollStatus     pollStatus
+	pollStatusLock sync.RWMutex
+}
+
+// How the server is shown in the UI.
+func (monitored *monitoredServer) DisplayName() string {
+	if monitored.Name == "" {
+		return "default"
+	}
+	return monitored.Name
+}
+
+func (monitored *monitoredServer) getCodeState() *codemanager.CodeState {
+	monitored.codeStateLock.RLock()
+	defer monitored.codeStateLock.RUnlock()
+	return monitored.codeState
+}
+
+// Update the store, then serve the updated state.
+func (monitored *monitoredServer) updateCodeState(update func(*codemanager.CodeState) error) error {
+	monitored.updateLock.Lock()
+	defer monitored.updateLock.Unlock()
+
+	codeState, err := monitored.Store.Update(update)
+	if err != nil {
+		return err
+	}
+
+	monitored.setCodeState(&codeState)
+	return nil
+}
+
+func (monitored *monitoredServer) setCodeState(codeState *codemanager.CodeState) {
+	monitored.codeStateLock.Lock()
+	monitored.codeState = codeState
+	monitored.codeStateLock.Unlock()
+
+	monitored.broker.publish(codeState)
+	wantControlRepoRefresh()
+}
+
+// Send events for anything that changed in the current state, e.g. commits
+// that have since been found in the control repo.
+func (monitored *monitoredServer) republish() {
+	monitored.updateLock.Lock()
+	defer monitored.updateLock.Unlock()
+
+	monitored.broker.publish(monitored.getCodeState())
+}
+
+func (server *webServer) findServer(name string) *monitoredServer {
+	for _, monitored := range server.Servers {
+		if monitored.Name == name {
+			return monitored
+		}
+	}
+
+	return nil
+}
+
+// The server named in the server query parameter, or the first server if the
+// parameter isn't set. Returns nil if there's no such server.
+func requestServer(ctx *fasthttp.RequestCtx) *monitoredServer {
+	args := ctx.QueryArgs()
+	if !args.Has("server") {
+		return server.Servers[0]
+	}
+
+	return server.findServer(string(args.Peek("server")))
+}
+
+// Like requestServer, but renders a 404 if there's no such server.
+func getRequestServer(ctx *fasthttp.RequestCtx) *monitoredServer {
+	monitored := requestServer(ctx)
+	if monitored == nil {
+		ctx.SetStatusCode(404)
+		fmt.Fprintf(ctx, "No such server %q", ctx.QueryArgs().Peek("server"))
+	}
+
+	return monitored
+}
+
+// Add the server query parameter to a path, unless this is the first server.
+func (monitored *monitoredServer) Link(path string) string {
+	if monitored == server.Servers[0] {
+		return path
+	}
+
+	separator := "?"
+	if parsed, err := url.Parse(path); err == nil && parsed.RawQuery != "" {
+		separator = "&"
+	}
+
+	return path + separator + "server=" + url.QueryEscape(monitored.Name)
+}
@@ -3,11 +3,15 @@
 	2019-01-12 00:15:35.150358 +0000 UTC
 */
 
+function formatDatetime(text) {
+  return moment(text, "YYYY-MM-DD HH:mm:ss ZZ").calendar();
+}
+
 $(document).ready(function(){
   $('datetime').each(function(){
     var $this = $(this);
     console.log($this.text());
-    $this.text(moment($this.text(), "YYYY-MM-DD HH:mm:ss ZZ").calendar());
+    $this.text(formatDatetime($this.text()));
     console.log($this.text());
   });
 });
@@ -0,0 +1,102 @@
+/*
+	Update the environments table from server-sent events.
+*/
+
+function laggingCompilerElement(compiler) {
+  var sha = compiler.Sha ? compiler.Sha.substring(0, 8) : "nothing";
+  return $('<div class="lagging">')
+    .append(document.createTextNode(compiler.Name + " has " + sha + " (checked in "))
+    .append($("<datetime>").text(formatDatetime(compiler.CheckedInAt)))
+    .append(document.createTextNode(")"));
+}
+
+// Link to an environment on the server the page is showing.
+function environmentUrl(name) {
+  var server = $("#environments").attr("data-server");
+  var url = "/environments/" + encodeURIComponent(name);
+  return server ? url + "?server=" + encodeURIComponent(server) : url;
+}
+
+function environmentRow(name) {
+  var $row = $("#environments tbody tr").filter(function(){
+    return $(this).attr("data-environment") === name;
+  });
+  if ($row.length) {
+    return $row;
+  }
+
+  // New environment: insert it in order.
+  $row = $("<tr>").attr("data-environment", name)
+    .append($("<th>").append($("<a>").attr("href", environmentUrl(name)).text(name)))
+    .append('<td class="status">', '<td class="time">');
+  if ($("#col_commit").length) {
+    $row.append('<td class="commit">');
+  }
+  $row.append('<td class="compilers">');
+
+  var $before = $("#environments tbody tr").filter(function(){
+    return $(this).attr("data-environment").toLowerCase() > name.toLowerCase();
+  }).first();
+  if ($before.length) {
+    $row.insertBefore($before);
+  } else {
+    $("#environments tbody").append($row);
+  }
+
+  return $row;
+}
+
+function highlight($row) {
+  $row.removeClass("changed");
+  // Force a reflow so the animation restarts.
+  void $row[0].offsetWidth;
+  $row.addClass("changed");
+}
+
+$(document).ready(function(){
+  if (!window.EventSource || !$("#environments").length) {
+    return;
+  }
+
+  var source = new EventSource($("#environments").attr("data-events"));
+
+  source.addEventListener("environment", function(event){
+    var summary = JSON.parse(event.data);
+    var $row = environmentRow(summary.Environment);
+
+    $row.find(".status").text(summary.Status);
+    $row.find(".time").empty().append(
+      $("<datetime>").text(formatDatetime(summary.Time)));
+    $row.find(".commit").empty().append(
+      $("<span>").text(summary.Commit || ""),
+      summary.Comparison ? $('<div class="comparison">').text(summary.Comparison) : null);
+    $row.find(".compilers").empty().append(
+      $.map(summary.Lagging, laggingCompilerElement));
+
+    highlight($row);
+  });
+
+  source.addEventListener("poll", function(event){
+    var summary = JSON.parse(event.data);
+    var $status = $("#poll-status").toggleClass("failing", summary.Failing);
+
+    $status.find(".error").empty();
+    if (summary.Failing) {
+      $status.find(".error").append(
+        document.createTextNode("Polling Code Manager failed at "),
+        $("<datetime>").text(formatDatetime(summary.LastErrorAt)),
+        document.createTextNode(": " + summary.LastError));
+    }
+
+    $status.find(".last-success").empty().append(
+      document.createTextNode("Last updated "),
+      summary.LastSuccess
+        ? $("<datetime>").text(formatDatetime(summary.LastSuccess))
+        : document.createTextNode("never"));
+  });
+
+  source.addEventListener("removed", function(event){
+    var summary = JSON.parse(event.data);
+    environmentRow(summary.Environment).remove();
+  });
+});
@@ -0,0 +1,62 @@
+package web
+
+import (
+	"fmt"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"time"
+)
+
+type statsWindow struct {
+	Name     string
+	Selected bool
+}
+
+var statsWindows = []string{"24h", "168h", "720h", "2160h"}
+
+type statsPage struct {
+	Windows      []statsWindow
+	Overall      codemanager.DeployStats
+	Environments []codemanager.DeployStats
+}
+
+func formatPercent(value float64) string {
+	return fmt.Sprintf("%.1f%%", value)
+}
+
+func Stats(ctx *fasthttp.RequestCtx) {
+	log.Infof("Stats: %v", ctx.URI())
+
+	monitored := getRequestServer(ctx)
+	if monitored == nil {
+		return
+	}
+
+	windowName := string(ctx.QueryArgs().Peek("window"))
+	if windowName == "" {
+		windowName = "168h"
+	}
+
+	window, err := time.ParseDuration(windowName)
+	if err != nil {
+		ctx.SetStatusCode(400)
+		fmt.Fprintf(ctx, "Invalid window %q", windowName)
+		return
+	}
+
+	page := statsPage{}
+	for _, name := range statsWindows {
+		page.Windows = append(page.Windows, statsWindow{
+			Name:     name,
+			Selected: name == windowName,
+		})
+	}
+
+	filter := codemanager.DeployFilter{Since: time.Now().Add(-window)}
+	deploys := monitored.getCodeState().QueryDeploys("", filter)
+	page.Overall, page.Environments = codemanager.ComputeStats(deploys)
+
+	// Errors are handled within render
+	render(ctx, "stats.jet", page)
+}
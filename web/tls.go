@@ -0,0 +1,77 @@
+package web
+
+import (
+	"crypto/tls"
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"net"
+)
+
+// Build the TLS configuration for the server, or return nil if TLS is off.
+func serverTlsConfig(options *Options) (*tls.Config, error) {
+	if options.TlsCert == "" && options.TlsKey == "" {
+		return nil, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(options.TlsCert, options.TlsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if options.TlsClientCa != "" {
+		// LoadCaCert builds a client configuration; we want the same pool to
+		// verify clients.
+		caConfig, err := codemanager.LoadCaCert(options.TlsClientCa)
+		if err != nil {
+			return nil, err
+		}
+
+		config.ClientCAs = caConfig.RootCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// Listen for HTTP or HTTPS connections, and serve them forever.
+func listenAndServe(options *Options, handler fasthttp.RequestHandler) error {
+	tlsConfig, err := serverTlsConfig(options)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", options.ListenOn)
+	if err != nil {
+		return err
+	}
+
+	if tlsConfig == nil {
+		log.Infof("Listening on http://%v", options.ListenOn)
+	} else {
+		listener = tls.NewListener(listener, tlsConfig)
+		if options.TlsClientCa != "" {
+			log.Infof("Listening on https://%v (client certificates required)",
+				options.ListenOn)
+		} else {
+			log.Infof("Listening on https://%v", options.ListenOn)
+		}
+	}
+
+	return fasthttp.Serve(listener, handler)
+}
+
+// The common name of the verified client certificate, or "".
+func clientCertificateName(ctx *fasthttp.RequestCtx) string {
+	state := ctx.TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return ""
+	}
+
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
@@ -0,0 +1,39 @@
+package web
+
+import (
+	"github.com/danielparks/code-manager-dashboard/codemanager"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Apply the retention policy to every server forever.
+func trim(retention codemanager.Retention, interval time.Duration) {
+	log.Infof("Applying retention policy every %v", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, monitored := range server.Servers {
+			err := monitored.updateCodeState(func(codeState *codemanager.CodeState) error {
+				for _, result := range retention.Apply(codeState, time.Now()) {
+					if result.Dropped {
+						log.Infof("Retention: removed environment %s from %s",
+							result.Environment, monitored.DisplayName())
+					} else {
+						log.Infof("Retention: removed %d deploys from %s on %s, keeping %d",
+							result.Removed, result.Environment, monitored.DisplayName(),
+							result.Kept)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				log.Errorf("Applying retention policy to %s: %v",
+					monitored.DisplayName(), err)
+			}
+		}
+
+		<-ticker.C
+	}
+}